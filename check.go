@@ -0,0 +1,147 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Problem is an issue found in a section of a Hapfile
+type Problem struct {
+	Section string
+	Message string
+}
+
+// loadProblem returns the problem for an error like [host one] message
+// An error without a section is reported for the file.
+func loadProblem(file string, err error) Problem {
+	msg := err.Error()
+	if strings.HasPrefix(msg, "[") {
+		if i := strings.Index(msg, "] "); i > 0 {
+			return Problem{msg[1:i], msg[i+2:]}
+		}
+	}
+	return Problem{file, msg}
+}
+
+// String returns the problem as [section] message
+func (p Problem) String() string {
+	return fmt.Sprintf("[%s] %s", p.Section, p.Message)
+}
+
+// Check loads the Hapfile and returns the problems found
+// Every problem found while loading is reported, and the sections that
+// load are checked too. Scripts and env files are found relative to the
+// current directory.
+func Check(file string) []Problem {
+	hf, errs, err := loadHapfile(file)
+	if err != nil {
+		return []Problem{{file, err.Error()}}
+	}
+	problems := append([]Problem{}, hf.duplicates...)
+	for _, err := range errs {
+		problems = append(problems, loadProblem(file, err))
+	}
+	reported := map[string]bool{}
+	for _, name := range sortedNames(hf.Hosts) {
+		h := hf.Host(name)
+		section := "host " + name
+		if h.Addr == "" {
+			problems = append(problems, Problem{section, "missing addr"})
+		}
+		if h.Identity != "" {
+			if err := checkIdentity(h.Identity); err != nil {
+				problems = append(problems, Problem{section, err.Error()})
+			}
+		}
+		if _, err := h.Render(); err != nil {
+			problems = append(problems, Problem{section, err.Error()})
+		}
+		for _, p := range checkFiles(section, h) {
+			reported[name+" "+p.Message] = true
+			problems = append(problems, p)
+		}
+	}
+	deploys := []string{}
+	for name := range hf.Deploys {
+		deploys = append(deploys, name)
+	}
+	sort.Strings(deploys)
+	for _, d := range deploys {
+		deploy := hf.Deploys[d]
+		section := "deploy " + d
+		for _, name := range deploy.Host {
+			if _, ok := hf.Hosts[name]; !ok {
+				problems = append(problems, Problem{section, fmt.Sprintf("unknown host '%s'", name)})
+			}
+		}
+		if deploy.RunOnceHost != "" && !contains(deploy.Host, deploy.RunOnceHost) {
+			problems = append(problems, Problem{section, fmt.Sprintf("run-once-host '%s' is not a host of the deploy", deploy.RunOnceHost)})
+		}
+		for _, name := range sortedNames(hf.deploys[d]) {
+			for _, p := range checkFiles(section, hf.DeployHost(d, name)) {
+				if !reported[name+" "+p.Message] {
+					problems = append(problems, p)
+				}
+			}
+		}
+	}
+	return unique(problems)
+}
+
+// checkIdentity reports whether the identity file can be read
+func checkIdentity(identity string) error {
+	file, err := NewKeyFile(identity)
+	if err == nil {
+		_, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return fmt.Errorf("unreadable identity '%s'", identity)
+	}
+	return nil
+}
+
+// checkFiles reports missing env files and scripts not executable in git
+func checkFiles(section string, h *Host) []Problem {
+	problems := []Problem{}
+	for _, env := range h.Env {
+		if _, err := os.Stat(env); err != nil {
+			problems = append(problems, Problem{section, fmt.Sprintf("missing env file '%s'", env)})
+		}
+	}
+	cmds := append(append(append([]string{}, h.Cmds()...), h.FailureCmds()...), h.AlwaysCmds()...)
+	for _, cmd := range cmds {
+		fields := strings.Fields(cmd)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "./") {
+			continue
+		}
+		switch new(Git).Mode(filepath.Clean(fields[0])) {
+		case "":
+			problems = append(problems, Problem{section, fmt.Sprintf("script '%s' is not committed", fields[0])})
+		case "100755":
+		default:
+			problems = append(problems, Problem{section, fmt.Sprintf("script '%s' is not executable in git", fields[0])})
+		}
+	}
+	return problems
+}
+
+// unique removes repeated problems and keeps the order
+func unique(problems []Problem) []Problem {
+	seen := map[Problem]bool{}
+	results := []Problem{}
+	for _, p := range problems {
+		if !seen[p] {
+			seen[p] = true
+			results = append(results, p)
+		}
+	}
+	return results
+}
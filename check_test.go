@@ -0,0 +1,102 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	cfgStr := `
+[host "one"]
+addr = "10.0.0.1:22"
+identity = TestMissingIdentity
+env = TestMissingEnv
+cmd = ./TestScript.sh
+cmd = echo ok
+
+[host "two"]
+username = deploy
+
+[deploy "release"]
+host = one
+host = three
+run-once-host = two
+
+[include]
+path = TestAnotherHapfile`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	cfgStr = `
+[host "two"]
+addr = "10.0.0.2:22"`
+	err = ioutil.WriteFile("TestAnotherHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	w := []string{
+		"[host two] defined in TestHapfile and TestAnotherHapfile, using TestHapfile",
+		"[host one] unreadable identity 'TestMissingIdentity'",
+		"[host one] missing env file 'TestMissingEnv'",
+		"[host one] script './TestScript.sh' is not committed",
+		"[host two] missing addr",
+		"[deploy release] unknown host 'three'",
+		"[deploy release] run-once-host 'two' is not a host of the deploy",
+	}
+	g := []string{}
+	for _, p := range Check("TestHapfile") {
+		g = append(g, p.String())
+	}
+	if !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+
+	cfgStr = `
+[host "one"]
+addr = "10.0.0.1:22"
+build = missing
+cmd = echo ${nope}
+
+[host "two"]
+addr = "10.0.0.2:22"
+inherit = ghost
+
+[template "t"]
+local-before = make test
+
+[include]
+path = TestMissingHapfile`
+	err = ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	w = []string{
+		"[TestHapfile] open TestMissingHapfile: no such file or directory",
+		"[template t] local-before and local-after are only used in default and deploy sections",
+		"[host two] inherits unknown host or template 'ghost'",
+		"[host one] unknown build 'missing'",
+		"[one] undefined var 'nope' in 'echo ${nope}'",
+	}
+	g = []string{}
+	for _, p := range Check("TestHapfile") {
+		g = append(g, p.String())
+	}
+	if !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	err = os.Remove("TestAnotherHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
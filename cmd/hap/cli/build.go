@@ -14,6 +14,11 @@ import (
 var force = flag.BoolP("force", "", false, "Force build even if it happened before.")
 var dry = flag.BoolP("dry", "", false, "Show commands without running them.")
 
+// IsDry returns whether commands should only be shown
+func IsDry() bool {
+	return *dry
+}
+
 // Add the build command
 func init() {
 	Commands.Add("build", &BuildCmd{})
@@ -34,6 +39,11 @@ func (cmd *BuildCmd) Help() string {
 
 // Run the build command on the remote host
 func (cmd *BuildCmd) Run(remote *hap.Remote) (string, error) {
+	files, err := remote.Host.Render()
+	if err != nil {
+		return fmt.Sprintf("[%s] render failed.", remote.Host.Name), err
+	}
+	remote.Files = files
 	if *dry {
 		result := fmt.Sprintf(
 			"[%s] --dry run.\n",
@@ -45,13 +55,28 @@ func (cmd *BuildCmd) Run(remote *hap.Remote) (string, error) {
 		for _, cmd := range cmds {
 			result = result + fmt.Sprintf("[%s] %s\n", remote.Host.Name, cmd)
 		}
+		for _, cmd := range remote.Host.FailureCmds() {
+			result = result + fmt.Sprintf("[%s] on-failure: %s\n", remote.Host.Name, cmd)
+		}
+		for _, cmd := range remote.Host.AlwaysCmds() {
+			result = result + fmt.Sprintf("[%s] always: %s\n", remote.Host.Name, cmd)
+		}
+		for _, f := range files {
+			result = result + fmt.Sprintf("[%s] file %s: %s %04o\n", remote.Host.Name, f.Name, f.Dest, f.Mode)
+		}
+		if err := remote.Diff(files); err != nil {
+			return result, err
+		}
 		result = result + fmt.Sprintf("[%s] --dry run completed.\n", remote.Host.Name)
 		return result, nil
 	}
 	if result, err := Commands.Get("push").Run(remote); err != nil {
 		return result, err
 	}
-	if err := remote.Build(*force); err != nil {
+	if err := remote.Build(*force); err == hap.ErrHappened {
+		result := fmt.Sprintf("[%s] build skipped. Commit again or use --force.", remote.Host.Name)
+		return result, err
+	} else if err != nil {
 		result := fmt.Sprintf("[%s] build failed.", remote.Host.Name)
 		return result, err
 	}
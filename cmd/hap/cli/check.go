@@ -0,0 +1,45 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gwoo/hap"
+)
+
+// Add the check command
+func init() {
+	Commands.Add("check", &CheckCmd{File: "Hapfile"})
+}
+
+// CheckCmd validates a Hapfile without connecting to hosts
+type CheckCmd struct {
+	File string
+}
+
+// IsRemote returns whether the command expects a remote or not
+func (cmd *CheckCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns the help on hap check
+func (cmd *CheckCmd) Help() string {
+	return "hap check\tValidate the Hapfile and report problems."
+}
+
+// Run the command without a remote
+func (cmd *CheckCmd) Run(remote *hap.Remote) (string, error) {
+	problems := hap.Check(cmd.File)
+	if len(problems) == 0 {
+		return fmt.Sprintf("check %s passed.", cmd.File), nil
+	}
+	lines := []string{}
+	for _, p := range problems {
+		lines = append(lines, p.String())
+	}
+	return strings.Join(lines, "\n"), fmt.Errorf("check %s found %d problems.", cmd.File, len(problems))
+}
@@ -0,0 +1,42 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gwoo/hap"
+	flag "github.com/ogier/pflag"
+)
+
+// Add the convert command
+func init() {
+	Commands.Add("convert", &ConvertCmd{File: "Hapfile"})
+}
+
+// ConvertCmd translates a Hapfile into another format
+type ConvertCmd struct {
+	File string
+}
+
+// IsRemote returns whether the command expects a remote or not
+func (cmd *ConvertCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns the help on hap convert <format>
+func (cmd *ConvertCmd) Help() string {
+	return "hap convert <format>\tPrint the Hapfile as " + strings.Join(hap.Formats, ", ") + "."
+}
+
+// Run the command without a remote
+func (cmd *ConvertCmd) Run(remote *hap.Remote) (string, error) {
+	if len(flag.Args()) <= 1 {
+		return "", fmt.Errorf("error: expects <format>")
+	}
+	b, err := hap.Convert(cmd.File, flag.Arg(1))
+	return strings.TrimSuffix(string(b), "\n"), err
+}
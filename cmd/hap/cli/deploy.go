@@ -30,6 +30,11 @@ func (cmd *DeployCmd) Help() string {
 
 // Run the build command on the remote host
 func (cmd *DeployCmd) Run(remote *hap.Remote) (string, error) {
+	files, err := remote.Host.Render()
+	if err != nil {
+		return fmt.Sprintf("[%s] render failed.", remote.Host.Name), err
+	}
+	remote.Files = files
 	if *dry {
 		result := fmt.Sprintf(
 			"[%s] --dry run.\n",
@@ -41,6 +46,18 @@ func (cmd *DeployCmd) Run(remote *hap.Remote) (string, error) {
 		for _, cmd := range cmds {
 			result = result + fmt.Sprintf("[%s] %s\n", remote.Host.Name, cmd)
 		}
+		for _, cmd := range remote.Host.FailureCmds() {
+			result = result + fmt.Sprintf("[%s] on-failure: %s\n", remote.Host.Name, cmd)
+		}
+		for _, cmd := range remote.Host.AlwaysCmds() {
+			result = result + fmt.Sprintf("[%s] always: %s\n", remote.Host.Name, cmd)
+		}
+		for _, f := range files {
+			result = result + fmt.Sprintf("[%s] file %s: %s %04o\n", remote.Host.Name, f.Name, f.Dest, f.Mode)
+		}
+		if err := remote.Diff(files); err != nil {
+			return result, err
+		}
 		result = result + fmt.Sprintf("[%s] --dry run completed.\n", remote.Host.Name)
 		return result, nil
 	}
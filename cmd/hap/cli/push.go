@@ -6,15 +6,51 @@ package cli
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/gwoo/hap"
+	flag "github.com/ogier/pflag"
 )
 
+var ref = flag.StringP("ref", "", "", "Branch, tag, or commit to push instead of the current branch.")
+var allowDirty = flag.BoolP("allow-dirty", "", false, "Push even if there are uncommitted changes.")
+var wip = flag.BoolP("wip", "", false, "Push a snapshot of the working tree, with uncommitted changes.")
+
 // Add the Push command
 func init() {
 	Commands.Add("push", &PushCmd{})
 }
 
+// Prepare checks the working tree once before the hosts are pushed
+// Uncommitted changes are refused unless --allow-dirty is set or a --ref
+// is pushed. With --wip a snapshot of the working tree is pushed instead.
+func Prepare() error {
+	if *wip {
+		if *ref != "" {
+			return fmt.Errorf("error: --wip can not be used with --ref")
+		}
+		if _, err := new(hap.Git).Snapshot(); err != nil {
+			return err
+		}
+		*ref = hap.WipRef
+		return nil
+	}
+	if *allowDirty || *ref != "" {
+		return nil
+	}
+	files, err := new(hap.Git).Status()
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf(
+			"error: uncommitted changes would not be pushed:\n%s\nCommit them, or use --allow-dirty or --wip.",
+			strings.Join(files, "\n"),
+		)
+	}
+	return nil
+}
+
 // PushCmd is the push command
 type PushCmd struct{}
 
@@ -30,6 +66,7 @@ func (cmd *PushCmd) Help() string {
 
 // Run takes a remote and pushes to it
 func (cmd *PushCmd) Run(remote *hap.Remote) (string, error) {
+	remote.Ref = *ref
 	fmt.Printf("[%s] connecting to %s\n", remote.Host.Name, remote.Host.Addr)
 	if err := remote.Push(); err != nil {
 		result := fmt.Sprintf("[%s] push failed.", remote.Host.Name)
@@ -39,6 +76,10 @@ func (cmd *PushCmd) Run(remote *hap.Remote) (string, error) {
 		result := fmt.Sprintf("[%s] push failed.", remote.Host.Name)
 		return result, err
 	}
+	if err := remote.Overlay(); err != nil {
+		result := fmt.Sprintf("[%s] overlay failed.", remote.Host.Name)
+		return result, err
+	}
 	result := fmt.Sprintf("[%s] push completed.", remote.Host.Name)
 	return result, nil
 }
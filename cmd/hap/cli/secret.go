@@ -0,0 +1,188 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gwoo/hap"
+	flag "github.com/ogier/pflag"
+	"golang.org/x/term"
+)
+
+// Add the secret command
+func init() {
+	Commands.Add("secret", &SecretCmd{})
+}
+
+// SecretCmd manages encrypted env files
+type SecretCmd struct{}
+
+// IsRemote returns whether the command expects a remote or not
+func (cmd *SecretCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns the help on hap secret
+func (cmd *SecretCmd) Help() string {
+	return "hap secret <edit|encrypt|decrypt> <file>\tEdit, encrypt, or print an encrypted env file."
+}
+
+// Run the command without a remote
+func (cmd *SecretCmd) Run(remote *hap.Remote) (string, error) {
+	if len(flag.Args()) <= 2 {
+		return "", fmt.Errorf("error: expects <edit|encrypt|decrypt> <file>")
+	}
+	file := flag.Arg(2)
+	switch flag.Arg(1) {
+	case "edit":
+		return edit(file)
+	case "encrypt":
+		return encrypt(file)
+	case "decrypt":
+		return decrypt(file)
+	}
+	return "", fmt.Errorf("error: unknown action '%s', expects edit, encrypt, or decrypt", flag.Arg(1))
+}
+
+// Passphrase returns HAP_PASSPHRASE or asks for it on the terminal
+// With confirm the passphrase is asked for twice.
+func Passphrase(confirm bool) (string, error) {
+	if p := os.Getenv("HAP_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("error: set HAP_PASSPHRASE to decrypt env files")
+	}
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(p) == 0 {
+		return "", fmt.Errorf("error: empty passphrase")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		c, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(p, c) {
+			return "", fmt.Errorf("error: passphrases do not match")
+		}
+	}
+	return string(p), nil
+}
+
+// encrypt replaces the plain env file with the encrypted one
+func encrypt(file string) (string, error) {
+	if hap.IsSecret(file) {
+		return "", fmt.Errorf("error: %s is already encrypted", file)
+	}
+	plain, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	passphrase, err := Passphrase(true)
+	if err != nil {
+		return "", err
+	}
+	if err := writeSecret(file, plain, passphrase); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s encrypted.", file), nil
+}
+
+// decrypt returns the plain env file without changing the file
+func decrypt(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	passphrase, err := Passphrase(false)
+	if err != nil {
+		return "", err
+	}
+	plain, err := hap.Decrypt(data, passphrase)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", file, err)
+	}
+	return strings.TrimSuffix(string(plain), "\n"), nil
+}
+
+// edit opens the decrypted env file in $EDITOR and encrypts the result
+// A file that does not exist is created.
+func edit(file string) (string, error) {
+	var plain []byte
+	var passphrase string
+	data, err := ioutil.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+		if passphrase, err = Passphrase(true); err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	case !hap.IsSecret(file):
+		return "", fmt.Errorf("error: %s is not encrypted, use hap secret encrypt", file)
+	default:
+		if passphrase, err = Passphrase(false); err != nil {
+			return "", err
+		}
+		if plain, err = hap.Decrypt(data, passphrase); err != nil {
+			return "", fmt.Errorf("%s: %s", file, err)
+		}
+	}
+	tmp, err := ioutil.TempFile("", "hap-secret-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(plain); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+" \"$1\"", "sh", tmp.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %s", editor, err)
+	}
+	edited, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		return "", err
+	}
+	if data != nil && bytes.Equal(plain, edited) {
+		return fmt.Sprintf("%s unchanged.", file), nil
+	}
+	if err := writeSecret(file, edited, passphrase); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s encrypted.", file), nil
+}
+
+// writeSecret encrypts the plain text into the file
+func writeSecret(file string, plain []byte, passphrase string) error {
+	data, err := hap.Encrypt(plain, passphrase)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
@@ -0,0 +1,87 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gwoo/hap"
+	flag "github.com/ogier/pflag"
+)
+
+var showJSON = flag.BoolP("json", "", false, "Show the configuration as JSON.")
+
+// Add the show command
+func init() {
+	Commands.Add("show", &ShowCmd{File: "Hapfile"})
+}
+
+// ShowCmd shows the resolved configuration of hosts
+type ShowCmd struct {
+	File string
+	Host string
+}
+
+// IsRemote returns whether the command expects a remote or not
+func (cmd *ShowCmd) IsRemote() bool {
+	return false
+}
+
+// Help returns the help on hap show
+func (cmd *ShowCmd) Help() string {
+	return "hap show [deploy]\tShow the resolved configuration of the hosts."
+}
+
+// Run the command without a remote
+func (cmd *ShowCmd) Run(remote *hap.Remote) (string, error) {
+	hf, err := hap.NewHapfile(cmd.File)
+	if err != nil {
+		return "", err
+	}
+	selection := cmd.Host
+	if selection == "" {
+		selection = "*"
+	}
+	configs, err := hf.Show(flag.Arg(1), selection)
+	if err != nil {
+		return "", err
+	}
+	if *showJSON {
+		b, err := json.MarshalIndent(configs, "", "  ")
+		return string(b), err
+	}
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	for _, c := range configs {
+		if c.Deploy != "" {
+			fmt.Fprintf(w, "[%s] deploy %s\n", c.Name, c.Deploy)
+		} else {
+			fmt.Fprintf(w, "[%s]\n", c.Name)
+		}
+		for _, v := range []struct {
+			key   string
+			value hap.Value
+		}{
+			{"addr", c.Addr},
+			{"username", c.Username},
+			{"dir", c.Dir},
+			{"identity", c.Identity},
+		} {
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", v.key, v.value.Value, v.value.Source)
+		}
+		for _, v := range c.Env {
+			fmt.Fprintf(w, "  env\t%s\t%s\n", v.Value, v.Source)
+		}
+		for _, v := range c.Cmd {
+			fmt.Fprintf(w, "  cmd\t%s\t%s\n", v.Value, v.Source)
+		}
+	}
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
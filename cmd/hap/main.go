@@ -5,34 +5,54 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"sort"
-	"sync"
+	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/gwoo/hap"
 	"github.com/gwoo/hap/cmd/hap/cli"
 	flag "github.com/ogier/pflag"
 )
 
-var host = flag.StringP("host", "h", "", "Host to use for commands. Use glob patterns to match multiple hosts. Use --host=* for all hosts.")
+var host = flag.StringP("host", "h", "", "Hosts to use for commands. Use globs (app-*), ranges (app-[01:12]), tags (@web), exclusions (!app-01), intersections (@web&@eu), and commas to combine. Use --host=* for all hosts.")
+var list = flag.BoolP("list", "", false, "List the matching hosts without connecting.")
 var hapfile = flag.StringP("file", "f", "Hapfile", "Location of a Hapfile.")
 var help = flag.BoolP("help", "", false, "Show help")
 var verbose = flag.BoolP("verbose", "v", false, "[deprecated] Verbose mode is always on")
+var batch = flag.StringP("batch", "", "", "Number or percentage of hosts to run at once.")
+var maxFail = flag.StringP("max-fail", "", "", "Number or percentage of failed hosts allowed before stopping.")
+var canary = flag.IntP("canary", "", 0, "Number of hosts to run first before asking to continue.")
+var yes = flag.BoolP("yes", "", false, "Continue after the canary hosts without asking.")
+var retryFailed = flag.BoolP("retry-failed", "", false, "Rerun the last command on the hosts that failed, were unreachable, or were not attempted.")
 
 var logger VerboseLogger
 
+// lastRun holds the previous run when retrying failed hosts
+var lastRun *hap.LastRun
+
+// secrets holds the decrypted env files by path
+var secrets map[string]string
+
 // Version is just the version of hap
 var Version string
 
+// Exit codes for failed runs
+const (
+	exitFailed = 1
+	exitUsage  = 2
+)
+
 func main() {
 	flag.Usage = Usage
 	flag.Parse()
 
 	if err := new(hap.Git).Exists(); err != nil {
 		fmt.Println(err)
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 	var command cli.Command
 	if cmd := flag.Arg(0); cmd != "" {
@@ -41,68 +61,255 @@ func main() {
 			fmt.Printf("Command `%s` not found.\n", cmd)
 		}
 	}
-	if len(os.Args) <= 1 || *help || command == nil {
+	if *help {
 		flag.Usage()
 		return
 	}
+	if len(os.Args) <= 1 || command == nil {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	*hapfile = hap.FindHapfile(*hapfile)
+	if c, ok := command.(*cli.ConvertCmd); ok {
+		c.File = *hapfile
+	}
+	if c, ok := command.(*cli.CheckCmd); ok {
+		c.File = *hapfile
+	}
+	if c, ok := command.(*cli.ShowCmd); ok {
+		c.File, c.Host = *hapfile, *host
+	}
 	if !command.IsRemote() {
-		run(nil, command)
+		if result := run(nil, command); result.Err != nil {
+			os.Exit(exitFailed)
+		}
 		return
 	}
 	hf, err := hap.NewHapfile(*hapfile)
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
-	var hosts = make(map[string]*hap.Host, 0)
-
+	if *retryFailed {
+		retry()
+	}
+	ro := &hap.Rollout{
+		Command: flag.Arg(0),
+		Run: func(h *hap.Host) hap.Result {
+			return run(h, command)
+		},
+		Ask:     ask,
+		Out:     os.Stdout,
+		Batch:   *batch,
+		MaxFail: *maxFail,
+		Canary:  *canary,
+		Yes:     *yes,
+		List:    *list,
+		LastRun: lastRun,
+	}
+	before, after := hf.LocalHooks("")
+	env := []string{
+		"HAP_COMMAND=" + flag.Arg(0),
+		"HAP_ARGS=" + strings.Join(flag.Args()[1:], " "),
+	}
+	var runHosts func() hap.Results
+	targets := []*hap.Host{}
 	if _, ok := command.(*cli.DeployCmd); ok {
-		deploy := flag.Arg(1)
 		if *host == "" {
 			*host = "*"
 		}
-		hosts, err = hf.GetDeployHosts(deploy, *host)
+		name := flag.Arg(1)
+		stages, err := hf.DeployStages(name)
 		if err != nil {
 			fmt.Println(err)
-			return
+			os.Exit(exitUsage)
+		}
+		names := []string{}
+		for _, stage := range stages {
+			hosts, _ := hf.GetDeployHosts(stage, *host)
+			for key, h := range hosts {
+				names = append(names, key)
+				targets = append(targets, h)
+			}
+		}
+		before, after = hf.LocalHooks(name)
+		env = append(env, "HAP_DEPLOY="+name, "HAP_HOSTS="+strings.Join(unique(names), " "))
+		runHosts = func() hap.Results {
+			results, err := ro.Deploy(hf, name, *host)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(exitUsage)
+			}
+			return results
 		}
-	} else if *host != "" {
-		hosts = hf.GetHosts(*host)
 	} else {
-		fmt.Println("Missing host please specify -h or --host=")
-		os.Exit(2)
+		if *host == "" {
+			fmt.Println("Missing host please specify -h or --host=")
+			os.Exit(exitUsage)
+		}
+		if _, err := hap.Select(hf.Hosts, *host); err != nil {
+			fmt.Println(err)
+			os.Exit(exitUsage)
+		}
+		hosts := lastRun.Retry(hf.GetHosts(*host), "")
+		if len(hosts) == 0 {
+			fmt.Println("No host found")
+			os.Exit(exitUsage)
+		}
+		names := []string{}
+		for key, h := range hosts {
+			names = append(names, key)
+			targets = append(targets, h)
+		}
+		env = append(env, "HAP_HOSTS="+strings.Join(unique(names), " "))
+		runHosts = func() hap.Results {
+			if *list {
+				hap.ListHosts(os.Stdout, hosts)
+				return nil
+			}
+			p, err := ro.Plan(len(hosts))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(exitUsage)
+			}
+			return ro.Hosts(hosts, p)
+		}
 	}
-	if len(hosts) == 0 {
-		fmt.Println("No host found")
+	if *list {
+		runHosts()
 		return
 	}
-	var wg sync.WaitGroup
-	for _, h := range hosts {
-		wg.Add(1)
-		go func(h *hap.Host) {
-			defer wg.Done()
-			run(h, command)
-		}(h)
+	switch command.(type) {
+	case *cli.BuildCmd, *cli.DeployCmd, *cli.ExecCmd, *cli.PushCmd:
+		if !cli.IsDry() {
+			if err := cli.Prepare(); err != nil {
+				fmt.Println(err)
+				os.Exit(exitUsage)
+			}
+		}
+	}
+	if cli.IsDry() {
+		before, after = nil, nil
+	} else if secrets, err = hap.Secrets(targets, func() (string, error) {
+		return cli.Passphrase(false)
+	}); err != nil {
+		fmt.Println(err)
+		os.Exit(exitFailed)
 	}
-	wg.Wait()
+	if err := hap.RunLocal(before, env); err != nil {
+		fmt.Println(err)
+		os.Exit(exitFailed)
+	}
+	results := runHosts()
+	fmt.Println()
+	results.Summary(os.Stdout)
+	if !cli.IsDry() {
+		file := hap.LastRunFile(*hapfile)
+		if err := hap.NewLastRun(runArgs(), results).Write(file); err != nil {
+			fmt.Println(err)
+		}
+	}
+	status := "success"
+	if results.Failed() {
+		status = "failed"
+	}
+	env = append(env,
+		"HAP_RESULT="+status,
+		"HAP_FAILED_HOSTS="+strings.Join(results.Names(hap.Failed, hap.Unreachable), " "),
+	)
+	if err := hap.RunLocal(after, env); err != nil {
+		fmt.Println(err)
+		os.Exit(exitFailed)
+	}
+	if results.Failed() {
+		os.Exit(exitFailed)
+	}
+}
+
+// unique returns the sorted names without duplicates
+func unique(names []string) []string {
+	sort.Strings(names)
+	result := []string{}
+	for i, name := range names {
+		if i == 0 || names[i-1] != name {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// runArgs returns the arguments of the run without --retry-failed
+func runArgs() []string {
+	args := []string{}
+	for _, arg := range os.Args[1:] {
+		if !strings.HasPrefix(arg, "--retry-failed") {
+			args = append(args, arg)
+		}
+	}
+	return args
 }
 
-func run(host *hap.Host, command cli.Command) {
+// retry loads the last run and replays its arguments and flags
+// Only the hosts that failed, were unreachable or not attempted run again.
+func retry() {
+	lr, err := hap.ReadLastRun(hap.LastRunFile(*hapfile))
+	if err != nil {
+		fmt.Println("No last run to retry:", err)
+		os.Exit(exitUsage)
+	}
+	given := flag.Args()
+	os.Args = append([]string{os.Args[0]}, lr.Args...)
+	flag.Parse()
+	if flag.Arg(0) != given[0] || (len(given) > 1 && strings.Join(given, " ") != strings.Join(flag.Args(), " ")) {
+		fmt.Printf("Last run was `hap %s`\n", strings.Join(lr.Args, " "))
+		os.Exit(exitUsage)
+	}
+	if !lr.HasFailed() {
+		fmt.Printf("Nothing failed in the last run of `hap %s`\n", strings.Join(lr.Args, " "))
+		os.Exit(exitUsage)
+	}
+	if *host == "" {
+		*host = "*"
+	}
+	lastRun = &lr
+}
+
+// stdin reads the answers of every question of the run
+var stdin = bufio.NewReader(os.Stdin)
+
+// ask prints the question and returns whether the answer was yes
+func ask(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := stdin.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func run(host *hap.Host, command cli.Command) hap.Result {
+	start := time.Now()
 	var remote *hap.Remote
 	var err error
+	name := ""
 	if host != nil {
+		name = host.Name
+		if err = host.ResolveSecrets(); err != nil {
+			fmt.Println(hap.Mask(err.Error()))
+			return hap.NewResult(name, start, err)
+		}
 		remote, err = hap.NewRemote(host)
 		if err != nil {
-			fmt.Println(err)
-			return
+			fmt.Println(hap.Mask(err.Error()))
+			return hap.NewResult(name, start, err)
 		}
 		defer remote.Close()
+		remote.Secrets = secrets
 	}
 	result, err := command.Run(remote)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println(hap.Mask(err.Error()))
 	}
-	fmt.Println(result)
+	fmt.Println(hap.Mask(result))
+	return hap.NewResult(name, start, err)
 }
 
 // Usage prints out the hap CLI usage
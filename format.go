@@ -0,0 +1,294 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	gcfg "gopkg.in/gcfg.v1"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Formats are the Hapfile formats besides git-config
+var Formats = []string{"yaml", "toml", "json"}
+
+// FindHapfile returns the file or the first Hapfile.<format> that exists
+func FindHapfile(file string) string {
+	if _, err := os.Stat(file); err == nil || filepath.Ext(file) != "" {
+		return file
+	}
+	for _, format := range append(Formats, "yml") {
+		if _, err := os.Stat(file + "." + format); err == nil {
+			return file + "." + format
+		}
+	}
+	return file
+}
+
+// format returns the format of the file from the extension
+// Files without a known extension use the git-config format.
+func format(file string) string {
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml", ".json":
+		return ext[1:]
+	}
+	return "gcfg"
+}
+
+// readInto reads the file into the hapfile using the format of the file
+// Sections are the top level keys, with a key for each name in
+// sections like host, and lists for keys that may be repeated.
+func readInto(hf *Hapfile, file string) error {
+	f := format(file)
+	if f == "gcfg" {
+		return gcfg.ReadFileInto(hf, file)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var data interface{}
+	switch f {
+	case "yaml":
+		err = yaml.Unmarshal(b, &data)
+	case "toml":
+		_, err = toml.Decode(string(b), &data)
+	case "json":
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&data)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	s, err := gcfgString(normalize(data))
+	if err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	if err := gcfg.ReadStringInto(hf, s); err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	return nil
+}
+
+// normalize converts yaml maps to maps with string keys
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, value := range v {
+			m[fmt.Sprint(key)] = normalize(value)
+		}
+		return m
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalize(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalize(value)
+		}
+		return v
+	}
+	return v
+}
+
+// sections returns the section names of the hapfile
+// The value is true for sections with names, like host.
+func sections() map[string]bool {
+	results := map[string]bool{}
+	t := reflect.TypeOf(Hapfile{})
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" {
+			results[gcfgName(f)] = f.Type.Kind() == reflect.Map
+		}
+	}
+	return results
+}
+
+// gcfgName returns the name of the field in a Hapfile
+func gcfgName(f reflect.StructField) string {
+	if name := f.Tag.Get("gcfg"); name != "" {
+		return name
+	}
+	return strings.ToLower(f.Name)
+}
+
+// gcfgString writes the sections as git-config
+func gcfgString(data interface{}) (string, error) {
+	top, ok := data.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("expects a map of sections")
+	}
+	named := sections()
+	var b bytes.Buffer
+	for _, section := range sortedKeys(top) {
+		hasNames, ok := named[section]
+		if !ok {
+			return "", fmt.Errorf("unknown section '%s'", section)
+		}
+		values, ok := top[section].(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("section '%s' expects a map", section)
+		}
+		if !hasNames {
+			fmt.Fprintf(&b, "[%s]\n", section)
+			if err := writeVars(&b, section, values); err != nil {
+				return "", err
+			}
+			continue
+		}
+		for _, name := range sortedKeys(values) {
+			vars, ok := values[name].(map[string]interface{})
+			if !ok && values[name] != nil {
+				return "", fmt.Errorf("%s '%s' expects a map", section, name)
+			}
+			fmt.Fprintf(&b, "[%s %s]\n", section, quote(name))
+			if err := writeVars(&b, section+" "+name, vars); err != nil {
+				return "", err
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// writeVars writes the vars of a section, a list is written as repeated vars
+// A key without a value is left out.
+func writeVars(b *bytes.Buffer, section string, vars map[string]interface{}) error {
+	for _, key := range sortedKeys(vars) {
+		list, ok := vars[key].([]interface{})
+		if !ok {
+			list = []interface{}{vars[key]}
+		}
+		for _, value := range list {
+			switch value.(type) {
+			case nil:
+				continue
+			case map[string]interface{}, []interface{}:
+				return fmt.Errorf("[%s] %s expects a value or a list of values", section, key)
+			}
+			if strings.HasPrefix(section, "file ") && key == "mode" {
+				value = octal(value)
+			}
+			fmt.Fprintf(b, "%s = %s\n", key, quote(fmt.Sprint(value)))
+		}
+	}
+	return nil
+}
+
+// octal returns an integer mode as its octal digits
+// YAML 0644 and TOML 0o644 are decoded to 420, while a mode is written like 644.
+func octal(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return fmt.Sprintf("%o", v)
+	case int64:
+		return fmt.Sprintf("%o", v)
+	case uint64:
+		return fmt.Sprintf("%o", v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return fmt.Sprintf("%o", i)
+		}
+	}
+	return value
+}
+
+// quote returns the value as a git-config quoted string
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// sortedKeys returns the sorted keys of the map
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Convert reads a Hapfile and returns it in the format
+// Included files are not read, the include paths are kept.
+func Convert(file, to string) ([]byte, error) {
+	var hf Hapfile
+	if err := readInto(&hf, file); err != nil {
+		return nil, err
+	}
+	data := map[string]interface{}{}
+	v := reflect.ValueOf(hf)
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Map {
+			named := map[string]interface{}{}
+			for _, key := range fv.MapKeys() {
+				named[key.String()] = settings(fv.MapIndex(key).Elem())
+			}
+			if len(named) > 0 {
+				data[gcfgName(f)] = named
+			}
+		} else if vars := settings(fv); len(vars) > 0 {
+			data[gcfgName(f)] = vars
+		}
+	}
+	switch to {
+	case "yaml":
+		return yaml.Marshal(data)
+	case "toml":
+		var b bytes.Buffer
+		err := toml.NewEncoder(&b).Encode(data)
+		return b.Bytes(), err
+	case "json":
+		b, err := json.MarshalIndent(data, "", "  ")
+		return append(b, '\n'), err
+	}
+	return nil, fmt.Errorf("unknown format '%s', expects one of %s", to, strings.Join(Formats, ", "))
+}
+
+// settings returns the settings of a section that are not empty
+func settings(v reflect.Value) map[string]interface{} {
+	vars := map[string]interface{}{}
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		fv := v.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.String, reflect.Slice:
+			if fv.Len() == 0 {
+				continue
+			}
+		case reflect.Bool:
+			if !fv.Bool() {
+				continue
+			}
+		case reflect.Int:
+			if fv.Int() == 0 {
+				continue
+			}
+		}
+		vars[gcfgName(f)] = fv.Interface()
+	}
+	return vars
+}
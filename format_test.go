@@ -0,0 +1,156 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNewHapfileWithFormats(t *testing.T) {
+	files := map[string]string{
+		"TestHapfile.yaml": `
+default:
+  username: root
+  build: init
+host:
+  one:
+    addr: "10.0.0.1:22"
+    password:
+    dir:
+    tag: [web, null]
+    cmd: [./one.sh, echo "one"]
+deploy:
+  release:
+    host: one
+    batch: 1
+    confirm: true
+file:
+  conf:
+    src: conf.tmpl
+    dest: conf
+    mode: 0644
+include:
+  path: [TestHapfile.toml, TestHapfile.json]`,
+		"TestHapfile.toml": `
+[host.two]
+addr = "10.0.0.2:22"
+dir = "/srv/two"
+
+[build.init]
+cmd = ["echo init"]
+
+[file.run]
+src = "run.tmpl"
+dest = "run"
+mode = 0o755`,
+		"TestHapfile.json": `{
+  "host": {"three": {"addr": "10.0.0.3:22", "env": ["three_environment"]}},
+  "env": {"file": "environment"},
+  "deploy": {"big": {"batch": 1000000}}
+}`,
+	}
+	for name, cfgStr := range files {
+		if err := ioutil.WriteFile(name, []byte(cfgStr), 0666); err != nil {
+			t.Error(err)
+		}
+	}
+	hf, err := NewHapfile("TestHapfile.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := []string{"echo init", "./one.sh", `echo "one"`}
+	if g := hf.Host("one").Cmds(); !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+	if g := hf.Host("two").Dir; g != "/srv/two" {
+		t.Error("Want:", "/srv/two", "Got:", g)
+	}
+	w = []string{"environment", "three_environment"}
+	if g := hf.Host("three").Env; !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+	if d := hf.Deploys["release"]; d.Batch != "1" || !d.Confirm {
+		t.Error("Want: batch 1 and confirm Got:", d.Batch, d.Confirm)
+	}
+	if h := hf.Host("one"); h.Password != "" || h.Dir != "" || !reflect.DeepEqual([]string{"web"}, h.Tag) {
+		t.Error("Want: empty password and dir, tags [web] Got:", h.Password, h.Dir, h.Tag)
+	}
+	if g := hf.Deploys["big"].Batch; g != "1000000" {
+		t.Error("Want: 1000000 Got:", g)
+	}
+	if wm, gm := "644 755", hf.Files["conf"].Mode+" "+hf.Files["run"].Mode; wm != gm {
+		t.Error("Want:", wm, "Got:", gm)
+	}
+
+	err = ioutil.WriteFile("TestHapfile.json", []byte(`{"hosts": {}}`), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := NewHapfile("TestHapfile.yaml"); err == nil {
+		t.Error("Expected error for unknown section")
+	}
+	for name := range files {
+		if err := os.Remove(name); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	cfgStr := `
+[default]
+username = root
+
+[host "one"]
+addr = "10.0.0.1:22"
+cmd = "echo \"one\""
+cmd = ./one.sh
+confirm = true
+
+[deploy "release"]
+host = one
+canary = 1
+
+[include]
+path = hosts.hap`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	var want Hapfile
+	if err := readInto(&want, "TestHapfile"); err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range Formats {
+		b, err := Convert("TestHapfile", format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := "TestHapfile." + format
+		if err := ioutil.WriteFile(file, b, 0666); err != nil {
+			t.Error(err)
+		}
+		var got Hapfile
+		if err := readInto(&got, file); err != nil {
+			t.Error(format, err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Error("Format:", format, "Want:", want, "Got:", got)
+		}
+		if err := os.Remove(file); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := Convert("TestHapfile", "ini"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
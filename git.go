@@ -6,10 +6,16 @@ package hap
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
+// WipRef holds the snapshot of the working tree pushed with --wip
+const WipRef = "refs/hap-wip"
+
 // Git struct
 type Git struct {
 	Repo string
@@ -71,6 +77,87 @@ func (g Git) RevParse() ([]byte, error) {
 	return cmd.CombinedOutput()
 }
 
+// Resolve returns the commit of a branch, tag, or sha
+func (g Git) Resolve(ref string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--verify", "-q", ref+"^{commit}")
+	cmd.Dir = g.Work
+	result, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("unknown ref '%s'", ref)
+	}
+	return strings.TrimSpace(string(result)), nil
+}
+
+// Status returns the changed and untracked files of the working tree
+// Each file is a line of git status --porcelain, like " M Hapfile".
+// The .hap directories with the last run and inventories are left out.
+func (g Git) Status() ([]string, error) {
+	cmd := exec.Command("git", "status", "--porcelain", "--untracked-files=all")
+	cmd.Dir = g.Work
+	result, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git status: %s", err)
+	}
+	files := []string{}
+	for _, line := range strings.Split(string(result), "\n") {
+		if len(line) < 4 || isState(line[3:]) {
+			continue
+		}
+		files = append(files, line)
+	}
+	return files, nil
+}
+
+// Snapshot commits the working tree, with untracked files, to WipRef
+// A temporary index is used so the index, HEAD, and branches are not changed.
+// Like Status, the .hap directories are left out.
+func (g Git) Snapshot() (string, error) {
+	f, err := ioutil.TempFile("", "hap-index-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	os.Remove(f.Name())
+	index, err := filepath.Abs(f.Name())
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(index)
+	git := func(args ...string) (string, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = g.Work
+		cmd.Env = append(os.Environ(), "GIT_INDEX_FILE="+index)
+		result, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %s\n%s", args[0], err, result)
+		}
+		return strings.TrimSpace(string(result)), nil
+	}
+	if _, err := git("read-tree", "HEAD"); err != nil {
+		return "", err
+	}
+	if _, err := git("add", "-A", "--", ".", ":(exclude,glob)**/.hap/**"); err != nil {
+		return "", err
+	}
+	tree, err := git("write-tree")
+	if err != nil {
+		return "", err
+	}
+	commit, err := git("commit-tree", tree, "-p", "HEAD", "-m", "hap wip")
+	if err != nil {
+		return "", err
+	}
+	if _, err := git("update-ref", WipRef, commit); err != nil {
+		return "", err
+	}
+	return commit, nil
+}
+
+// isState reports whether the path is in a .hap directory
+func isState(path string) bool {
+	return strings.HasPrefix(path, ".hap/") || strings.Contains(path, "/.hap/")
+}
+
 // UpdateSubmodules updates and initializes submodules
 func (g Git) UpdateSubmodules() ([]byte, error) {
 	cmd := exec.Command("git", "submodule", "update", "--init")
@@ -78,6 +165,17 @@ func (g Git) UpdateSubmodules() ([]byte, error) {
 	return cmd.CombinedOutput()
 }
 
+// Files returns the files committed at ref under the dir
+func (g Git) Files(ref, dir string) ([]string, error) {
+	cmd := exec.Command("git", "ls-tree", "-r", "--name-only", ref, "--", dir)
+	cmd.Dir = g.Work
+	result, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-tree %s: %s", ref, err)
+	}
+	return strings.Fields(string(result)), nil
+}
+
 // Add this hook to the remote repo
 const postReceiveHook string = `cat > ".git/hooks/post-receive" << "EOF"
 #!/bin/bash
@@ -89,3 +187,19 @@ branch=${ref#refs/heads/}
 git reset -q --hard
 git checkout -q ${branch}
 EOF`
+
+// Mode returns the mode of a file in the git index, like 100755
+// It returns an empty string if the file is not in the index.
+func (g Git) Mode(file string) string {
+	cmd := exec.Command("git", "ls-files", "-s", "--", file)
+	cmd.Dir = g.Work
+	result, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(result))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
@@ -8,6 +8,9 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -63,3 +66,93 @@ func TestGitPush(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestGitResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hap-resolve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	git := Git{Work: dir}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=hap", "-c", "user.email=hap@localhost", "commit", "-q", "--allow-empty", "-m", "one"},
+		{"tag", "v1"},
+		{"-c", "user.name=hap", "-c", "user.email=hap@localhost", "commit", "-q", "--allow-empty", "-m", "two"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatal(string(out), err)
+		}
+	}
+	v1, err := git.Resolve("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := git.Resolve("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v1) != 40 || v1 == head {
+		t.Error("Want: v1 and HEAD to be different commits Got:", v1, head)
+	}
+	if _, err := git.Resolve("missing"); err == nil {
+		t.Error("Expected an error for an unknown ref")
+	}
+}
+
+func TestGitStatusAndSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hap-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "Hapfile"), []byte("[default]\n"), 0644)
+	run := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=hap", "-c", "user.email=hap@localhost"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatal(string(out), err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	run("init", "-q")
+	run("add", ".")
+	run("commit", "-q", "-m", "one")
+	git := Git{Work: dir}
+	files, err := git.Status()
+	if err != nil || len(files) != 0 {
+		t.Error("Want: a clean tree Got:", files, err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "Hapfile"), []byte("[default]\nusername = app\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "new.sh"), []byte("echo new\n"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".hap"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ".hap", "last-run.json"), []byte("{}"), 0644)
+	w := []string{" M Hapfile", "?? new.sh"}
+	if files, _ = git.Status(); !reflect.DeepEqual(w, files) {
+		t.Error("Want:", w, "Got:", files)
+	}
+
+	head := run("rev-parse", "HEAD")
+	run("config", "user.name", "hap")
+	run("config", "user.email", "hap@localhost")
+	commit, err := git.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := run("rev-parse", WipRef); g != commit {
+		t.Error("Want:", commit, "Got:", g)
+	}
+	if g := run("rev-parse", "HEAD"); g != head {
+		t.Error("Want: HEAD unchanged", head, "Got:", g)
+	}
+	snapshot, _ := git.Files(WipRef, ".")
+	if w := []string{"Hapfile", "new.sh"}; !reflect.DeepEqual(w, snapshot) {
+		t.Error("Want:", w, "Got:", snapshot)
+	}
+	if files, _ = git.Status(); len(files) != 2 {
+		t.Error("Want: the working tree unchanged Got:", files)
+	}
+}
@@ -12,121 +12,320 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
-
-	gcfg "gopkg.in/gcfg.v1"
+	"strings"
 )
 
 // Hapfile defines the hosts, builds, and default
 type Hapfile struct {
-	Default Default
-	Deploys map[string]*Deploy `gcfg:"deploy"`
-	deploys map[string]map[string]*Host
-	Hosts   map[string]*Host  `gcfg:"host"`
-	Builds  map[string]*Build `gcfg:"build"`
-	Include Include           `gcfg:"include"`
-	Env     Env               `gcfg:"env"`
+	Default     Default
+	Deploys     map[string]*Deploy `gcfg:"deploy"`
+	deploys     map[string]map[string]*Host
+	Hosts       map[string]*Host      `gcfg:"host"`
+	Templates   map[string]*Host      `gcfg:"template"`
+	Builds      map[string]*Build     `gcfg:"build"`
+	Include     Include               `gcfg:"include"`
+	Env         Env                   `gcfg:"env"`
+	Vars        Vars                  `gcfg:"vars"`
+	Inventories map[string]*Inventory `gcfg:"inventory"`
+	Files       map[string]*File      `gcfg:"file"`
+	sources     map[string]string
+	duplicates  []Problem
+	raw         map[string]*Host
+	// inventoried maps the hosts from an inventory to its name
+	inventoried map[string]string
 }
 
 // NewHapfile constructs a new hapfile config
+// The first problem found while loading is returned as the error.
 func NewHapfile(file string) (Hapfile, error) {
+	hf, errs, err := loadHapfile(file)
+	if err == nil && len(errs) > 0 {
+		err = errs[0]
+	}
+	return hf, err
+}
+
+// loadHapfile constructs a new hapfile config with every problem found
+// The error is set when the file itself can not be read.
+func loadHapfile(file string) (Hapfile, []error, error) {
+	errs := []error{}
+	hf, err := load(file, nil, map[string]bool{}, &errs)
+	if err != nil {
+		return hf, nil, err
+	}
+	errs = append(errs, hf.inventory(file)...)
+	hf.deploys = map[string]map[string]*Host{}
+	hf.raw = map[string]*Host{}
+	for n, h := range hf.Hosts {
+		hf.raw["host "+n] = h.copy()
+	}
+	for n, t := range hf.Templates {
+		hf.raw["template "+n] = t.copy()
+	}
+	errs = append(errs, hf.checkLocalHooks()...)
+	errs = append(errs, hf.inherit()...)
+	defaults := hf.defaults()
+	for n, host := range hf.Hosts {
+		hf.Hosts[n] = MergeHost(defaults, host)
+	}
+	for d, deploy := range hf.Deploys {
+		hf.deploys[d] = map[string]*Host{}
+		for _, n := range deploy.Host {
+			if _, ok := hf.Hosts[n]; ok {
+				hf.deploys[d][n] = MergeHost(deployParent(hf.Hosts[n], defaults, deploy), deploy.host())
+			}
+		}
+	}
+	errs = append(errs, hf.checkBuilds()...)
+	errs = append(errs, hf.checkFileNames()...)
+	errs = append(errs, hf.checkVars()...)
+	return hf, errs, nil
+}
+
+// checkBuilds reports unknown build names and requires cycles
+func (hf Hapfile) checkBuilds() []error {
+	refs := map[string][]string{"default": hf.Default.Build}
+	for n, b := range hf.Builds {
+		refs["build "+n] = b.Requires
+	}
+	for n, h := range hf.Hosts {
+		refs["host "+n] = h.Build
+	}
+	for n, d := range hf.Deploys {
+		refs["deploy "+n] = d.Build
+	}
+	keys := []string{}
+	for key := range refs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	errs := []error{}
+	for _, key := range keys {
+		if _, err := BuildOrder(hf.Builds, refs[key]); err != nil {
+			errs = append(errs, fmt.Errorf("[%s] %s", key, err))
+		}
+	}
+	return errs
+}
+
+// checkLocalHooks reports local-before and local-after in hosts and templates
+// They only run from the default and deploy sections.
+func (hf Hapfile) checkLocalHooks() []error {
+	keys := []string{}
+	for key := range hf.raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	errs := []error{}
+	for _, key := range keys {
+		if h := hf.raw[key]; len(h.LocalBefore) > 0 || len(h.LocalAfter) > 0 {
+			errs = append(errs, fmt.Errorf("[%s] local-before and local-after are only used in default and deploy sections", key))
+		}
+	}
+	return errs
+}
+
+// load reads the file and the files it includes
+// Include paths are globs relative to the including file. The including
+// file takes precedence over the files it includes, which take
+// precedence in the order they are listed. Problems with the includes
+// are added to errs and the other includes are still loaded.
+func load(file string, stack []string, loaded map[string]bool, errs *[]error) (Hapfile, error) {
 	hf, err := include(file)
 	if err != nil {
 		return hf, err
 	}
-	hf.deploys = map[string]map[string]*Host{}
-	for _, file := range hf.Include.Path {
-		nhf, err := include(file)
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return hf, err
+	}
+	loaded[abs] = true
+	stack = append(append([]string{}, stack...), abs)
+	for _, pattern := range hf.Include.Path {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(file), pattern)
+		}
+		files, err := filepath.Glob(pattern)
 		if err != nil {
-			return hf, err
+			*errs = append(*errs, fmt.Errorf("invalid include path '%s': %s", pattern, err))
+			continue
 		}
-		for n, c := range nhf.Deploys {
-			if _, ok := hf.Deploys[n]; !ok {
-				hf.Deploys[n] = c
-			}
+		if len(files) == 0 && !isGlob(pattern) {
+			files = []string{pattern}
 		}
-		for n, h := range nhf.Hosts {
-			if _, ok := hf.Hosts[n]; !ok {
-				hf.Hosts[n] = h
+		for _, f := range files {
+			nabs, err := filepath.Abs(f)
+			if err != nil {
+				*errs = append(*errs, err)
+				continue
 			}
-		}
-		for n, b := range nhf.Builds {
-			if _, ok := hf.Builds[n]; !ok {
-				hf.Builds[n] = b
+			if contains(stack, nabs) {
+				*errs = append(*errs, fmt.Errorf("include cycle %s -> %s", strings.Join(stack, " -> "), nabs))
+				continue
 			}
-		}
-		for d, deploy := range nhf.Deploys {
-			hf.deploys[d] = map[string]*Host{}
-			for _, n := range deploy.Host {
-				if _, ok := hf.Hosts[n]; ok {
-					hf.deploys[d][n] = hf.newDeployHost(hf.Hosts[n], deploy)
-				}
+			if loaded[nabs] {
+				continue
+			}
+			nhf, err := load(f, stack, loaded, errs)
+			if err != nil {
+				*errs = append(*errs, err)
+				continue
 			}
+			hf.merge(nhf)
 		}
-		for _, file := range nhf.Env.File {
-			hf.Env.File = append(hf.Env.File, file)
+	}
+	return hf, nil
+}
+
+// merge adds the sections of an included hapfile
+// Hosts, templates, builds, files, and deploys already defined are kept.
+// The default section is merged onto the included one, and env files
+// and vars of the include come first so they are overridden.
+func (hf *Hapfile) merge(nhf Hapfile) {
+	for n, d := range nhf.Deploys {
+		if hf.define(nhf, "deploy "+n) {
+			hf.Deploys[n] = d
 		}
 	}
-	for _, host := range hf.Hosts {
-		for _, file := range hf.Env.File {
-			host.Env = append(host.Env, file)
+	for n, h := range nhf.Hosts {
+		if hf.define(nhf, "host "+n) {
+			hf.Hosts[n] = h
 		}
-		for i, j := 0, len(host.Env)-1; i < j; i, j = i+1, j-1 {
-			host.Env[i], host.Env[j] = host.Env[j], host.Env[i]
+	}
+	for n, t := range nhf.Templates {
+		if hf.define(nhf, "template "+n) {
+			hf.Templates[n] = t
 		}
 	}
-	for d, deploy := range hf.Deploys {
-		hf.deploys[d] = map[string]*Host{}
-		for _, n := range deploy.Host {
-			if _, ok := hf.Hosts[n]; ok {
-				hf.deploys[d][n] = hf.newDeployHost(hf.Hosts[n], deploy)
-			}
+	for n, b := range nhf.Builds {
+		if hf.define(nhf, "build "+n) {
+			hf.Builds[n] = b
 		}
 	}
-	return hf, err
+	for n, inv := range nhf.Inventories {
+		if hf.define(nhf, "inventory "+n) {
+			hf.Inventories[n] = inv
+		}
+	}
+	for n, f := range nhf.Files {
+		if hf.define(nhf, "file "+n) {
+			hf.Files[n] = f
+		}
+	}
+	hf.duplicates = append(hf.duplicates, nhf.duplicates...)
+	for key, file := range nhf.sources {
+		if _, ok := hf.sources[key]; !ok {
+			hf.sources[key] = file
+		}
+	}
+	d := Host(hf.Default)
+	parent := Host(nhf.Default)
+	hf.Default = Default(*MergeHost(&parent, &d))
+	hf.Env.File = append(nhf.Env.File, hf.Env.File...)
+	hf.Vars.Var = append(nhf.Vars.Var, hf.Vars.Var...)
+}
+
+// define records the file of a section from an included hapfile
+// It returns false and records a duplicate if the section is defined.
+func (hf *Hapfile) define(nhf Hapfile, section string) bool {
+	file := nhf.sources[section]
+	if first, ok := hf.sources[section]; ok {
+		hf.duplicates = append(hf.duplicates, Problem{
+			section, fmt.Sprintf("defined in %s and %s, using %s", first, file, first),
+		})
+		return false
+	}
+	hf.sources[section] = file
+	return true
+}
+
+// Source returns the file that defines a section like "host one"
+func (hf Hapfile) Source(section string) string {
+	return hf.sources[section]
+}
+
+// isGlob reports whether the path has glob characters
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// contains reports whether the list has the value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 func include(file string) (Hapfile, error) {
 	var hf Hapfile
-	err := gcfg.ReadFileInto(&hf, file)
+	err := readInto(&hf, file)
 	if hf.Deploys == nil {
 		hf.Deploys = make(map[string]*Deploy, 0)
 	}
 	if hf.Hosts == nil {
 		hf.Hosts = make(map[string]*Host, 0)
 	}
+	if hf.Templates == nil {
+		hf.Templates = make(map[string]*Host, 0)
+	}
 	if hf.Builds == nil {
 		hf.Builds = make(map[string]*Build, 0)
 	}
+	if hf.Inventories == nil {
+		hf.Inventories = make(map[string]*Inventory, 0)
+	}
+	if hf.Files == nil {
+		hf.Files = make(map[string]*File, 0)
+	}
+	hf.sources = map[string]string{}
+	for n := range hf.Deploys {
+		hf.sources["deploy "+n] = file
+	}
+	for n := range hf.Hosts {
+		hf.sources["host "+n] = file
+	}
+	for n := range hf.Templates {
+		hf.sources["template "+n] = file
+	}
+	for n := range hf.Builds {
+		hf.sources["build "+n] = file
+	}
+	for n := range hf.Inventories {
+		hf.sources["inventory "+n] = file
+	}
+	for n := range hf.Files {
+		hf.sources["file "+n] = file
+	}
+	d := Host(hf.Default)
+	for key, value := range d.scalars() {
+		if *value != "" {
+			hf.sources["default "+key] = file
+		}
+	}
+	for key, list := range d.lists() {
+		if len(*list) > 0 {
+			hf.sources["default "+key] = file
+		}
+	}
+	for _, f := range hf.Env.File {
+		hf.sources["env "+f] = file
+	}
 	return hf, err
 }
 
-func (hf Hapfile) newDeployHost(host *Host, d *Deploy) *Host {
-	h := &Host{}
-	h.Name = host.Name
-	h.Addr = host.Addr
-	h.Dir = host.Dir
-	h.Username = host.Username
-	h.Identity = host.Identity
-	h.Password = host.Password
-	h.Env = append(host.Env, d.Env...)
-	h.Build = d.Build
-	h.Cmd = d.Cmd
-	return h
-}
-
-// GetDeployHosts finds a list of hosts matching name string
+// GetDeployHosts finds a list of hosts matching the selection
 func (hf Hapfile) GetDeployHosts(deploy, host string) (map[string]*Host, error) {
 	hosts, ok := hf.deploys[deploy]
 	if !ok {
 		return map[string]*Host{}, fmt.Errorf("deploy '%s' not found", deploy)
 	}
-	keys := []string{}
-	for key := range hosts {
-		if matched, _ := filepath.Match(host, key); matched == true {
-			keys = append(keys, key)
-		}
+	keys, err := Select(hosts, host)
+	if err != nil {
+		return map[string]*Host{}, err
 	}
-	sort.Strings(keys)
 	results := make(map[string]*Host)
 	for _, key := range keys {
 		results[key] = hf.DeployHost(deploy, key)
@@ -134,35 +333,134 @@ func (hf Hapfile) GetDeployHosts(deploy, host string) (map[string]*Host, error)
 	return results, nil
 }
 
+// DeployStageHosts finds the hosts of a stage of the named deploy
+// Hosts ask for confirmation when the deploy, or a staged deploy that
+// runs the stage, sets confirm.
+func (hf Hapfile) DeployStageHosts(deploy, stage, host string) (map[string]*Host, error) {
+	hosts, err := hf.GetDeployHosts(stage, host)
+	if err != nil {
+		return hosts, err
+	}
+	if hf.stageConfirm(deploy, stage) {
+		for _, h := range hosts {
+			h.Confirm = true
+		}
+	}
+	return hosts, nil
+}
+
+// stageConfirm returns whether the deploy or a deploy between it and the stage sets confirm
+func (hf Hapfile) stageConfirm(deploy, stage string) bool {
+	d, ok := hf.Deploys[deploy]
+	if !ok {
+		return false
+	}
+	if deploy == stage {
+		return d.Confirm
+	}
+	stages, err := hf.DeployStages(deploy)
+	if err != nil || !contains(stages, stage) {
+		return false
+	}
+	if d.Confirm {
+		return true
+	}
+	for _, s := range d.Stage {
+		if hf.stageConfirm(s, stage) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeployStages returns the ordered deploys to run for the named deploy
+// A deploy without stages is its own single stage.
+func (hf Hapfile) DeployStages(deploy string) ([]string, error) {
+	return hf.deployStages(deploy, map[string]bool{})
+}
+
+func (hf Hapfile) deployStages(deploy string, seen map[string]bool) ([]string, error) {
+	d, ok := hf.Deploys[deploy]
+	if !ok {
+		return nil, fmt.Errorf("deploy '%s' not found", deploy)
+	}
+	if len(d.Stage) == 0 {
+		return []string{deploy}, nil
+	}
+	if seen[deploy] {
+		return nil, fmt.Errorf("deploy '%s' includes itself as a stage", deploy)
+	}
+	seen[deploy] = true
+	defer delete(seen, deploy)
+	stages := []string{}
+	for _, stage := range d.Stage {
+		s, err := hf.deployStages(stage, seen)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, s...)
+	}
+	return stages, nil
+}
+
+// RunOnce returns a host that runs only the run-once cmds of the deploy
+// The host is the run-once-host or the first of the hosts.
+// It returns nil if the deploy has no run-once cmds.
+func (hf Hapfile) RunOnce(deploy string, hosts map[string]*Host) (*Host, error) {
+	d, ok := hf.Deploys[deploy]
+	if !ok {
+		return nil, fmt.Errorf("deploy '%s' not found", deploy)
+	}
+	if len(d.RunOnce) == 0 || len(hosts) == 0 {
+		return nil, nil
+	}
+	name := d.RunOnceHost
+	if name == "" {
+		keys := []string{}
+		for key := range hosts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		name = keys[0]
+	}
+	host, ok := hosts[name]
+	if !ok {
+		return nil, fmt.Errorf("run-once-host '%s' is not a selected host of deploy '%s'", name, deploy)
+	}
+	h := *host
+	h.Build = nil
+	h.Cmd = d.RunOnce
+	h.files = nil
+	h.BuildCmds(hf.Builds)
+	if err := hf.interpolateCmds(&h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
 // DeployHost takes a name and returns the host
 // If the name is empty and default addr exists return default.
 // If no default is set it returns a random host.
 func (hf Hapfile) DeployHost(deploy, host string) *Host {
 	if h, ok := hf.deploys[deploy][host]; ok {
-		h.Name = host
-		h.SetDefaults(hf.Default)
-		h.BuildCmds(hf.Builds)
+		h = hf.resolve(host, h)
+		hf.interpolate(h)
 		return h
 	}
 	if hf.Default.Addr != "" {
 		h := Host(hf.Default)
 		h.Name = "default"
 		h.BuildCmds(hf.Builds)
+		hf.interpolate(&h)
 		return &h
 	}
 	return nil
 }
 
-// GetHosts finds a list of hosts matching name string
+// GetHosts finds a list of hosts matching the selection
+// An invalid selection matches no hosts, use Select to get the error.
 func (hf Hapfile) GetHosts(name string) map[string]*Host {
-	hosts := hf.Hosts
-	keys := []string{}
-	for key := range hosts {
-		if matched, _ := filepath.Match(name, key); matched == true {
-			keys = append(keys, key)
-		}
-	}
-	sort.Strings(keys)
+	keys, _ := Select(hf.Hosts, name)
 	results := make(map[string]*Host)
 	for _, key := range keys {
 		results[key] = hf.Host(key)
@@ -175,20 +473,30 @@ func (hf Hapfile) GetHosts(name string) map[string]*Host {
 // If no default is set it returns a random host.
 func (hf Hapfile) Host(name string) *Host {
 	if host, ok := hf.Hosts[name]; ok {
-		host.Name = name
-		host.SetDefaults(hf.Default)
-		host.BuildCmds(hf.Builds)
+		host = hf.resolve(name, host)
+		hf.interpolate(host)
 		return host
 	}
 	if hf.Default.Addr != "" {
 		host := Host(hf.Default)
 		host.Name = "default"
 		host.BuildCmds(hf.Builds)
+		hf.interpolate(&host)
 		return &host
 	}
 	return nil
 }
 
+// resolve returns a copy of the host with the builds applied
+// Vars are not interpolated so the errors can be checked on load.
+func (hf Hapfile) resolve(name string, host *Host) *Host {
+	h := *host
+	h.Name = name
+	h.BuildCmds(hf.Builds)
+	h.files = hf.hostFiles(h.File)
+	return &h
+}
+
 // String returns the hapfile config as json
 func (hf Hapfile) String() string {
 	b, err := json.Marshal(hf)
@@ -200,10 +508,32 @@ func (hf Hapfile) String() string {
 
 // Deploy describes a group of remote machine
 type Deploy struct {
-	Host  []string
-	Build []string
-	Cmd   []string
-	Env   []string
+	Host    []string
+	Build   []string
+	Cmd     []string
+	Env     []string
+	Stage   []string
+	Var     []string
+	File    []string
+	Batch   string
+	MaxFail string `gcfg:"max-fail"`
+	Canary  int
+	Confirm bool
+	// Append and Override name the lists to merge onto the hosts
+	// with a mode other than the one in MergeModes.
+	Append   []string
+	Override []string
+	// OnFailure runs on the host after a failed cmd and Always runs after all cmds
+	OnFailure []string `gcfg:"on-failure"`
+	Always    []string
+	// RunOnce runs on only one of the hosts, before the other cmds
+	// unless RunOnceAfter is set.
+	RunOnce      []string `gcfg:"run-once"`
+	RunOnceHost  string   `gcfg:"run-once-host"`
+	RunOnceAfter bool     `gcfg:"run-once-after"`
+	// LocalBefore and LocalAfter run once on the local machine
+	LocalBefore []string `gcfg:"local-before"`
+	LocalAfter  []string `gcfg:"local-after"`
 }
 
 // Default holds the default settings
@@ -220,32 +550,40 @@ type Host struct {
 	Env      []string
 	Build    []string
 	Cmd      []string
-	cmds     []string
+	Tag      []string
+	Var      []string
+	File     []string
+	Confirm  bool
+	// Inherit names a template or host to merge this host onto.
+	// Append and Override name the lists to merge with a mode
+	// other than the one in MergeModes.
+	Inherit  string
+	Append   []string
+	Override []string
+	// OnFailure runs after a failed cmd and Always runs after all cmds
+	OnFailure []string `gcfg:"on-failure"`
+	Always    []string
+	// LocalBefore and LocalAfter are only allowed in the default section
+	LocalBefore []string `gcfg:"local-before"`
+	LocalAfter  []string `gcfg:"local-after"`
+	cmds        []string
+	onFailure   []string
+	always      []string
+	files       []hostFile
+	vars        map[string]string
 }
 
-// SetDefaults fills in missing host specific configs with defaults
+// SetDefaults merges the host onto the defaults
 func (h *Host) SetDefaults(d Default) {
-	if h.Addr == "" {
-		h.Addr = d.Addr
-	}
-	if h.Dir == "" {
-		h.Dir = d.Dir
-	}
-	if h.Username == "" {
-		h.Username = d.Username
-	}
-	if h.Identity == "" {
-		h.Identity = d.Identity
-	}
-	if h.Password == "" {
-		h.Password = d.Password
-	}
-	if len(h.Build) < 1 {
-		h.Build = d.Build
-	}
-	if len(h.Cmd) < 1 {
-		h.Cmd = d.Cmd
-	}
+	base := Host(d)
+	*h = *MergeHost(&base, h)
+}
+
+// copy returns a copy of the host that does not share the env files
+func (h *Host) copy() *Host {
+	c := *h
+	c.Env = append([]string{}, h.Env...)
+	return &c
 }
 
 // GetDir returns the current working directory
@@ -261,14 +599,21 @@ func (h *Host) GetDir() string {
 }
 
 // BuildCmds combines the builds and cmds
+// Builds are expanded with their requires so each build runs once.
+// The handlers of the builds run before the handlers of the host.
 func (h *Host) BuildCmds(builds map[string]*Build) {
 	h.cmds = []string{}
-	for _, build := range h.Build {
-		if b, ok := builds[build]; ok {
-			h.cmds = append(h.cmds, b.Cmd...)
-		}
+	h.onFailure = []string{}
+	h.always = []string{}
+	order, _ := BuildOrder(builds, h.Build)
+	for _, build := range order {
+		h.cmds = append(h.cmds, builds[build].Cmd...)
+		h.onFailure = append(h.onFailure, builds[build].OnFailure...)
+		h.always = append(h.always, builds[build].Always...)
 	}
 	h.cmds = append(h.cmds, h.Cmd...)
+	h.onFailure = append(h.onFailure, h.OnFailure...)
+	h.always = append(h.always, h.Always...)
 }
 
 // Cmds returns the cmds to build
@@ -276,9 +621,24 @@ func (h *Host) Cmds() []string {
 	return h.cmds
 }
 
+// FailureCmds returns the cmds to run after a failed cmd
+func (h *Host) FailureCmds() []string {
+	return h.onFailure
+}
+
+// AlwaysCmds returns the cmds to run after the cmds whether they failed or not
+func (h *Host) AlwaysCmds() []string {
+	return h.always
+}
+
 // AddEnv includes env files in cmds
+// Encrypted env files are read from the variable the remote sets before the cmds.
 func (h *Host) AddEnv(cmds []string) []string {
-	for _, file := range h.Env {
+	for i, file := range h.Env {
+		if IsSecret(file) {
+			cmds = append(cmds, fmt.Sprintf("eval \"$%s\"", secretVar(i)))
+			continue
+		}
 		cmds = append(cmds, fmt.Sprint(". ./", file))
 	}
 	return cmds
@@ -286,7 +646,50 @@ func (h *Host) AddEnv(cmds []string) []string {
 
 // Build holds the cmds
 type Build struct {
-	Cmd []string
+	Cmd       []string
+	Requires  []string
+	OnFailure []string `gcfg:"on-failure"`
+	Always    []string
+}
+
+// BuildOrder resolves the named builds and everything they require
+// Each build is listed once and after all the builds it requires.
+// Unknown builds and cycles are returned as errors along with the
+// builds that could be resolved.
+func BuildOrder(builds map[string]*Build, names []string) ([]string, error) {
+	order := []string{}
+	state := map[string]int{}
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("build cycle %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		b, ok := builds[name]
+		if !ok {
+			if len(path) > 0 {
+				return fmt.Errorf("unknown build '%s' required by '%s'", name, path[len(path)-1])
+			}
+			return fmt.Errorf("unknown build '%s'", name)
+		}
+		state[name] = 1
+		for _, req := range b.Requires {
+			if err := visit(req, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return order, err
+		}
+	}
+	return order, nil
 }
 
 // Include holds the files to include
@@ -645,3 +645,417 @@ cmd = "echo init"`
 		t.Error(err)
 	}
 }
+
+func TestNewHapfileWithDeployStages(t *testing.T) {
+	cfgStr := `
+[host "db"]
+addr = "10.0.0.1:22"
+
+[host "app"]
+addr = "10.0.0.2:22"
+
+[deploy "migrate"]
+host = db
+cmd = ./migrate.sh
+
+[deploy "update"]
+host = app
+cmd = ./update.sh
+
+[deploy "release"]
+stage = migrate
+stage = update
+
+[deploy "guarded"]
+stage = release
+confirm = true
+
+[deploy "loop"]
+stage = loop`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	w := []string{"migrate", "update"}
+	g, err := hf.DeployStages("release")
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+	w = []string{"migrate"}
+	g, err = hf.DeployStages("migrate")
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+	if _, err := hf.DeployStages("loop"); err == nil {
+		t.Error("Expected error for stage cycle")
+	}
+	if _, err := hf.DeployStages("missing"); err == nil {
+		t.Error("Expected error for unknown deploy")
+	}
+	for _, test := range []struct {
+		deploy, stage string
+		want          bool
+	}{
+		{"guarded", "migrate", true},
+		{"guarded", "update", true},
+		{"release", "migrate", false},
+		{"migrate", "migrate", false},
+	} {
+		hosts, err := hf.DeployStageHosts(test.deploy, test.stage, "*")
+		if err != nil || len(hosts) != 1 {
+			t.Fatal(test.deploy, test.stage, hosts, err)
+		}
+		for _, h := range hosts {
+			if h.Confirm != test.want {
+				t.Error(test.deploy, test.stage, "Want:", test.want, "Got:", h.Confirm)
+			}
+		}
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildOrder(t *testing.T) {
+	builds := map[string]*Build{
+		"base":    &Build{Cmd: []string{"echo base"}},
+		"deps":    &Build{Cmd: []string{"echo deps"}, Requires: []string{"base"}},
+		"app":     &Build{Cmd: []string{"echo app"}, Requires: []string{"deps", "base"}},
+		"assets":  &Build{Cmd: []string{"echo assets"}, Requires: []string{"deps"}},
+		"loop":    &Build{Requires: []string{"cycle"}},
+		"cycle":   &Build{Requires: []string{"loop"}},
+		"missing": &Build{Requires: []string{"nope"}},
+	}
+	w := []string{"base", "deps", "app", "assets"}
+	g, err := BuildOrder(builds, []string{"app", "assets"})
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+	if _, err := BuildOrder(builds, []string{"loop"}); err == nil {
+		t.Error("Expected error for build cycle")
+	}
+	if _, err := BuildOrder(builds, []string{"missing"}); err == nil {
+		t.Error("Expected error for unknown required build")
+	}
+	if _, err := BuildOrder(builds, []string{"unknown"}); err == nil {
+		t.Error("Expected error for unknown build")
+	}
+
+	h := &Host{Build: []string{"app", "deps"}, Cmd: []string{"echo"}}
+	h.BuildCmds(builds)
+	w = []string{"echo base", "echo deps", "echo app", "echo"}
+	if !reflect.DeepEqual(w, h.Cmds()) {
+		t.Error("Want:", w, "Got:", h.Cmds())
+	}
+}
+
+func TestNewHapfileWithUnknownBuild(t *testing.T) {
+	cfgStr := `
+[host "one"]
+addr = "10.0.0.1:22"
+build = init
+
+[build "init"]
+cmd = "echo init"
+requires = setup`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := NewHapfile("TestHapfile"); err == nil {
+		t.Error("Expected error for unknown build")
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewHapfileWithConfirm(t *testing.T) {
+	cfgStr := `
+[host "db"]
+addr = "10.0.0.1:22"
+confirm = true
+
+[host "app"]
+addr = "10.0.0.2:22"
+
+[deploy "app"]
+host = app
+canary = 1
+
+[deploy "prod"]
+host = app
+confirm = true`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	if !hf.Host("db").Confirm {
+		t.Error("Expected db to require confirm")
+	}
+	if hf.Host("app").Confirm {
+		t.Error("Expected app to not require confirm")
+	}
+	if hf.DeployHost("app", "app").Confirm {
+		t.Error("Expected app deploy to not require confirm")
+	}
+	if !hf.DeployHost("prod", "app").Confirm {
+		t.Error("Expected prod deploy to require confirm")
+	}
+	if hf.Deploys["app"].Canary != 1 {
+		t.Error("Want: 1 Got:", hf.Deploys["app"].Canary)
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewHapfileWithBatch(t *testing.T) {
+	cfgStr := `
+[host "one"]
+addr = "10.0.0.1:22"
+
+[deploy "rolling"]
+host = one
+batch = 25%
+max-fail = 1`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	d := hf.Deploys["rolling"]
+	if d.Batch != "25%" || d.MaxFail != "1" {
+		t.Error("Want: 25% 1 Got:", d.Batch, d.MaxFail)
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewHapfileWithLocalHooks(t *testing.T) {
+	cfgStr := `
+[default]
+local-before = make test
+
+[deploy "release"]
+local-before = make assets
+local-after = git tag release`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	w1 := []string{"make test"}
+	if !reflect.DeepEqual(w1, hf.Default.LocalBefore) {
+		t.Error("Want:", w1, "Got:", hf.Default.LocalBefore)
+	}
+	w2 := []string{"git tag release"}
+	if !reflect.DeepEqual(w2, hf.Deploys["release"].LocalAfter) {
+		t.Error("Want:", w2, "Got:", hf.Deploys["release"].LocalAfter)
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewHapfileWithHandlers(t *testing.T) {
+	cfgStr := `
+[host "one"]
+addr = "10.0.0.1:22"
+build = app
+on-failure = ./maintenance.sh off
+
+[deploy "release"]
+host = one
+build = app
+always = ./notify.sh
+
+[build "app"]
+cmd = ./update.sh
+on-failure = ./restart.sh`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	p := hf.DeployHost("release", "one")
+	w1 := []string{"./restart.sh", "./maintenance.sh off"}
+	if !reflect.DeepEqual(w1, p.FailureCmds()) {
+		t.Error("Want:", w1, "Got:", p.FailureCmds())
+	}
+	w2 := []string{"./notify.sh"}
+	if !reflect.DeepEqual(w2, p.AlwaysCmds()) {
+		t.Error("Want:", w2, "Got:", p.AlwaysCmds())
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewHapfileWithRunOnce(t *testing.T) {
+	cfgStr := `
+[host "one"]
+addr = "10.0.0.1:22"
+
+[host "two"]
+addr = "10.0.0.2:22"
+
+[deploy "release"]
+host = one
+host = two
+cmd = ./update.sh
+run-once = ./migrate.sh
+
+[deploy "pinned"]
+host = one
+host = two
+run-once = ./migrate.sh
+run-once-host = two
+run-once-after = true
+
+[deploy "missing"]
+host = one
+run-once = ./migrate.sh
+run-once-host = three`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	hosts, err := hf.GetDeployHosts("release", "*")
+	if err != nil {
+		t.Error(err)
+	}
+	once, err := hf.RunOnce("release", hosts)
+	if err != nil {
+		t.Error(err)
+	}
+	if once.Name != "one" {
+		t.Error("Want: one Got:", once.Name)
+	}
+	w := []string{"./migrate.sh"}
+	if !reflect.DeepEqual(w, once.Cmds()) {
+		t.Error("Want:", w, "Got:", once.Cmds())
+	}
+	w = []string{"./update.sh"}
+	if !reflect.DeepEqual(w, hosts["one"].Cmds()) {
+		t.Error("Want:", w, "Got:", hosts["one"].Cmds())
+	}
+	hosts, _ = hf.GetDeployHosts("pinned", "*")
+	once, err = hf.RunOnce("pinned", hosts)
+	if err != nil {
+		t.Error(err)
+	}
+	if once.Name != "two" || !hf.Deploys["pinned"].RunOnceAfter {
+		t.Error("Want: two after Got:", once.Name, hf.Deploys["pinned"].RunOnceAfter)
+	}
+	hosts, _ = hf.GetDeployHosts("missing", "*")
+	if _, err := hf.RunOnce("missing", hosts); err == nil {
+		t.Error("Expected error for unknown run-once-host")
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewHapfileWithRecursiveInclude(t *testing.T) {
+	if err := os.MkdirAll("testinclude/hosts", 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("testinclude")
+	files := map[string]string{
+		"testinclude/Hapfile": `
+[default]
+dir = /srv/app
+
+[include]
+path = hosts/*.hap
+path = builds.hap`,
+		"testinclude/hosts/one.hap": `
+[host "one"]
+addr = "10.0.0.1:22"
+
+[include]
+path = ../builds.hap`,
+		"testinclude/hosts/two.hap": `
+[host "two"]
+addr = "10.0.0.2:22"`,
+		"testinclude/builds.hap": `
+[default]
+dir = /srv/other
+username = deploy
+build = test
+
+[build "test"]
+cmd = "echo test"`,
+	}
+	for name, cfgStr := range files {
+		if err := ioutil.WriteFile(name, []byte(cfgStr), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hf, err := NewHapfile("testinclude/Hapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hf.GetHosts("*")) != 2 {
+		t.Error("Expected two hosts")
+	}
+	p := hf.Host("two")
+	ws := []string{"/srv/app", "deploy"}
+	gs := []string{p.Dir, p.Username}
+	if !reflect.DeepEqual(ws, gs) {
+		t.Error("Want:", ws, "Got:", gs)
+	}
+	w := []string{"echo test"}
+	if !reflect.DeepEqual(w, p.Cmds()) {
+		t.Error("Want:", w, "Got:", p.Cmds())
+	}
+
+	cfgStr := `
+[include]
+path = ../Hapfile`
+	if err := ioutil.WriteFile("testinclude/hosts/two.hap", []byte(cfgStr), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHapfile("testinclude/Hapfile"); err == nil {
+		t.Error("Expected error for include cycle")
+	}
+}
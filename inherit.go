@@ -0,0 +1,67 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// inherit merges every host and template onto the one it inherits from
+// Templates are found before hosts with the same name. A host or template
+// that can not be merged is kept as is and its problem is returned.
+func (hf Hapfile) inherit() []error {
+	resolved := map[string]*Host{}
+	var resolve func(kind, name string, h *Host, path []string) (*Host, error)
+	resolve = func(kind, name string, h *Host, path []string) (*Host, error) {
+		key := kind + " " + name
+		if r, ok := resolved[key]; ok {
+			return r, nil
+		}
+		for _, p := range path {
+			if p == key {
+				return nil, fmt.Errorf("inherit cycle %s -> %s", strings.Join(path, " -> "), key)
+			}
+		}
+		if h.Inherit == "" {
+			resolved[key] = h
+			return h, nil
+		}
+		pkind, parent := "template", hf.Templates[h.Inherit]
+		if parent == nil {
+			pkind, parent = "host", hf.Hosts[h.Inherit]
+		}
+		if parent == nil {
+			resolved[key] = h
+			return nil, fmt.Errorf("[%s] inherits unknown host or template '%s'", key, h.Inherit)
+		}
+		p, err := resolve(pkind, h.Inherit, parent, append(path, key))
+		if err != nil {
+			resolved[key] = h
+			return nil, err
+		}
+		merged := inheritHost(p, h)
+		resolved[key] = merged
+		return merged, nil
+	}
+	errs := []error{}
+	for _, name := range sortedNames(hf.Templates) {
+		if _, err := resolve("template", name, hf.Templates[name], nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, name := range sortedNames(hf.Hosts) {
+		if _, err := resolve("host", name, hf.Hosts[name], nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for name := range hf.Hosts {
+		hf.Hosts[name] = resolved["host "+name]
+	}
+	for name := range hf.Templates {
+		hf.Templates[name] = resolved["template "+name]
+	}
+	return errs
+}
@@ -0,0 +1,93 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNewHapfileWithInherit(t *testing.T) {
+	cfgStr := `
+[template "base"]
+username = deploy
+build = init
+
+[template "web"]
+inherit = base
+tag = web
+build = nginx
+append = build
+
+[host "web-01"]
+inherit = web
+addr = "10.0.0.1:22"
+
+[host "web-02"]
+inherit = web-01
+addr = "10.0.0.2:22"
+username = admin
+
+[build "init"]
+cmd = echo init
+
+[build "nginx"]
+cmd = echo nginx`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	p := hf.Host("web-02")
+	if p.Addr != "10.0.0.2:22" || p.Username != "admin" {
+		t.Error("Unexpected settings:", p.Addr, p.Username)
+	}
+	w := []string{"echo init", "echo nginx"}
+	if !reflect.DeepEqual(w, p.Cmds()) {
+		t.Error("Want:", w, "Got:", p.Cmds())
+	}
+	if len(hf.GetHosts("@web")) != 2 {
+		t.Error("Expected two web hosts")
+	}
+	if len(hf.GetHosts("base")) != 0 {
+		t.Error("Expected templates to not be hosts")
+	}
+
+	cfgStr = `
+[template "a"]
+inherit = b
+
+[template "b"]
+inherit = a
+
+[host "one"]
+inherit = a`
+	err = ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := NewHapfile("TestHapfile"); err == nil {
+		t.Error("Expected error for inherit cycle")
+	}
+	cfgStr = `
+[host "one"]
+inherit = missing`
+	err = ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := NewHapfile("TestHapfile"); err == nil {
+		t.Error("Expected error for unknown inherit")
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
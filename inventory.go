@@ -0,0 +1,214 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Inventory describes a source of hosts
+// Exec is a command that prints the hosts as JSON. The output is
+// cached for the TTL, a duration like 5m. Terraform is a state file
+// or the output of terraform show -json, with a host for each resource
+// of the types rendered from the templates like addr = ${public_ip}:22.
+type Inventory struct {
+	Exec      string
+	TTL       string
+	Terraform string
+	Type      []string
+	Name      string
+	Addr      string
+	Username  string
+	Identity  string
+	Dir       string
+	Inherit   string
+	Tag       []string
+	Var       []string
+	Build     []string
+}
+
+// InventoryHost is a host in the output of an inventory
+type InventoryHost struct {
+	Name     string            `json:"name"`
+	Addr     string            `json:"addr"`
+	Username string            `json:"username,omitempty"`
+	Identity string            `json:"identity,omitempty"`
+	Dir      string            `json:"dir,omitempty"`
+	Inherit  string            `json:"inherit,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Vars     map[string]string `json:"vars,omitempty"`
+	Builds   []string          `json:"builds,omitempty"`
+}
+
+// Host returns the inventory host as a host
+// Vars starting with ! are escaped, so inventory data never runs a command.
+func (ih InventoryHost) Host() *Host {
+	h := &Host{
+		Name:     ih.Name,
+		Addr:     ih.Addr,
+		Username: ih.Username,
+		Identity: ih.Identity,
+		Dir:      ih.Dir,
+		Inherit:  ih.Inherit,
+		Tag:      ih.Tags,
+		Build:    ih.Builds,
+	}
+	names := []string{}
+	for name := range ih.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := ih.Vars[name]
+		if strings.HasPrefix(value, "!") {
+			value = "!" + value
+		}
+		h.Var = append(h.Var, name+"="+value)
+	}
+	return h
+}
+
+// escapeVars escapes ${ as $${, so the value is not interpolated
+func escapeVars(s string) string {
+	return strings.Replace(s, "${", "$${", -1)
+}
+
+// escapeHosts escapes ${ in the settings and vars interpolated by the Hapfile
+// The output of an exec is data, so it never expands a var or the env.
+func escapeHosts(hosts []InventoryHost) []InventoryHost {
+	for i, ih := range hosts {
+		for _, s := range []*string{&ih.Addr, &ih.Username, &ih.Identity, &ih.Dir} {
+			*s = escapeVars(*s)
+		}
+		vars := map[string]string{}
+		for name, value := range ih.Vars {
+			vars[name] = escapeVars(value)
+		}
+		ih.Vars = vars
+		hosts[i] = ih
+	}
+	return hosts
+}
+
+// InventoryFile returns the location of the cached inventory next to the hapfile
+func InventoryFile(hapfile, name string) string {
+	return filepath.Join(filepath.Dir(hapfile), ".hap", "inventory-"+name+".json")
+}
+
+// inventoryCache is the cached output of an exec
+type inventoryCache struct {
+	Exec  string          `json:"exec"`
+	Hosts json.RawMessage `json:"hosts"`
+}
+
+// Hosts returns the hosts of the inventory
+// The exec runs in dir, the directory of the hapfile, where a relative
+// terraform file is read too. The cache file of an exec is used when it
+// is newer than the TTL and was written for the same exec.
+func (inv *Inventory) Hosts(dir, cache string) ([]InventoryHost, error) {
+	if inv.Terraform != "" {
+		return inv.terraformHosts(dir)
+	}
+	var ttl time.Duration
+	if inv.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(inv.TTL); err != nil {
+			return nil, fmt.Errorf("invalid ttl '%s'", inv.TTL)
+		}
+	}
+	hosts := []InventoryHost{}
+	if info, err := os.Stat(cache); err == nil && time.Since(info.ModTime()) < ttl {
+		b, err := ioutil.ReadFile(cache)
+		if err != nil {
+			return nil, err
+		}
+		var c inventoryCache
+		if json.Unmarshal(b, &c) == nil && c.Exec == inv.Exec && json.Unmarshal(c.Hosts, &hosts) == nil {
+			return escapeHosts(hosts), nil
+		}
+	}
+	if inv.Exec == "" {
+		return nil, fmt.Errorf("missing exec")
+	}
+	cmd := exec.Command("sh", "-c", inv.Exec)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	b, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", inv.Exec, err)
+	}
+	if err := json.Unmarshal(b, &hosts); err != nil {
+		return nil, fmt.Errorf("%s: expects a JSON list of hosts: %s", inv.Exec, err)
+	}
+	if ttl > 0 {
+		if err := os.MkdirAll(filepath.Dir(cache), 0755); err != nil {
+			return nil, err
+		}
+		c, err := json.Marshal(inventoryCache{inv.Exec, b})
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(cache, c, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return escapeHosts(hosts), nil
+}
+
+// inventory adds the hosts of the inventories
+// Hosts defined in the hapfile or an earlier inventory are kept.
+func (hf *Hapfile) inventory(hapfile string) []error {
+	hf.inventoried = map[string]string{}
+	names := []string{}
+	for name := range hf.Inventories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := []error{}
+	for _, name := range names {
+		file, ok := hf.sources["inventory "+name]
+		if !ok {
+			file = hapfile
+		}
+		hosts, err := hf.Inventories[name].Hosts(filepath.Dir(file), InventoryFile(hapfile, name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("[inventory %s] %s", name, err))
+			continue
+		}
+		for _, ih := range hosts {
+			if ih.Name == "" {
+				errs = append(errs, fmt.Errorf("[inventory %s] host without a name", name))
+				continue
+			}
+			if strings.Contains(ih.Name, "${") {
+				errs = append(errs, fmt.Errorf("[inventory %s] invalid host name '%s'", name, ih.Name))
+				continue
+			}
+			section := "host " + ih.Name
+			if _, ok := hf.Hosts[ih.Name]; ok {
+				first := hf.sources[section]
+				if inv, ok := hf.inventoried[ih.Name]; ok {
+					first = "inventory " + inv
+				}
+				hf.duplicates = append(hf.duplicates, Problem{
+					section, fmt.Sprintf("defined in %s and inventory %s, using %s", first, name, first),
+				})
+				continue
+			}
+			hf.Hosts[ih.Name] = ih.Host()
+			hf.sources[section] = hf.sources["inventory "+name]
+			hf.inventoried[ih.Name] = name
+		}
+	}
+	return errs
+}
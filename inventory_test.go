@@ -0,0 +1,133 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNewHapfileWithInventory(t *testing.T) {
+	script := `#!/bin/sh
+echo '[
+  {"name": "web-01", "addr": "10.0.0.1:22", "tags": ["web"], "vars": {"role": "app", "token": "!touch TestPwned", "note": "${nope} ${env.HOME}"}, "builds": ["app"]},
+  {"name": "one", "addr": "10.0.0.9:22"}
+]'`
+	err := ioutil.WriteFile("TestInventory.sh", []byte(script), 0755)
+	if err != nil {
+		t.Error(err)
+	}
+	cfgStr := `
+[default]
+username = deploy
+
+[inventory "cloud"]
+exec = ./TestInventory.sh
+ttl = 1h
+
+[host "one"]
+addr = "10.0.0.1:22"
+
+[build "app"]
+cmd = echo ${role}
+cmd = echo ${token}
+cmd = echo ${note}`
+	err = ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	cache := InventoryFile("TestHapfile", "cloud")
+	defer os.Remove(cache)
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := hf.Host("web-01")
+	if p.Addr != "10.0.0.1:22" || p.Username != "deploy" {
+		t.Error("Unexpected settings:", p.Addr, p.Username)
+	}
+	if err := p.ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("TestPwned"); !os.IsNotExist(err) {
+		os.Remove("TestPwned")
+		t.Error("Want: inventory vars never run Got:", err)
+	}
+	w := []string{"echo app", "echo !touch TestPwned", "echo ${nope} ${env.HOME}"}
+	if !reflect.DeepEqual(w, p.Cmds()) {
+		t.Error("Want:", w, "Got:", p.Cmds())
+	}
+	if len(hf.GetHosts("@web")) != 1 {
+		t.Error("Expected one web host")
+	}
+	if g := hf.Host("one").Addr; g != "10.0.0.1:22" {
+		t.Error("Want:", "10.0.0.1:22", "Got:", g)
+	}
+	configs, err := hf.Show("", "web-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := configs[0].Addr.Source; g != "TestHapfile [inventory cloud]" {
+		t.Error("Want:", "TestHapfile [inventory cloud]", "Got:", g)
+	}
+
+	err = ioutil.WriteFile("TestInventory.sh", []byte("#!/bin/sh\necho '[]'"), 0755)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err = NewHapfile("TestHapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hf.Hosts["web-01"]; !ok {
+		t.Error("Expected the cached inventory")
+	}
+	os.Remove(cache)
+	hf, err = NewHapfile("TestHapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hf.Hosts["web-01"]; ok {
+		t.Error("Expected the inventory to run again")
+	}
+
+	err = ioutil.WriteFile("TestInventory.sh", []byte("#!/bin/sh\nexit 1"), 0755)
+	if err != nil {
+		t.Error(err)
+	}
+	os.Remove(cache)
+	if _, err := NewHapfile("TestHapfile"); err == nil {
+		t.Error("Expected error for failed inventory")
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	err = os.Remove("TestInventory.sh")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInventoryDirAndCache(t *testing.T) {
+	defer os.RemoveAll("TestInventoryDir")
+	os.MkdirAll("TestInventoryDir", 0755)
+	ioutil.WriteFile("TestInventoryDir/inventory.sh", []byte(`echo '[{"name": "'$1'", "addr": "10.0.0.5:22"}]'`), 0755)
+	for _, host := range []string{"sub-01", "sub-02"} {
+		cfgStr := "[inventory \"sub\"]\nexec = sh ./inventory.sh " + host + "\nttl = 1h"
+		if err := ioutil.WriteFile("TestInventoryDir/Hapfile", []byte(cfgStr), 0666); err != nil {
+			t.Fatal(err)
+		}
+		hf, err := NewHapfile("TestInventoryDir/Hapfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := hf.Hosts[host]; !ok || len(hf.Hosts) != 1 {
+			t.Error("Want:", host, "Got:", hf.Hosts)
+		}
+	}
+}
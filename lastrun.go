@@ -0,0 +1,135 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// LastRun holds the command and host results of the previous run
+type LastRun struct {
+	Args  []string   `json:"args"`
+	Hosts []LastHost `json:"hosts"`
+}
+
+// LastHost holds the status of a host in the previous run
+// RunOnce marks the result of the run-once cmds of a deploy.
+type LastHost struct {
+	Stage   string `json:"stage,omitempty"`
+	Host    string `json:"host"`
+	RunOnce bool   `json:"run_once,omitempty"`
+	Status  Status `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// LastRunFile returns the location of the last run next to the hapfile
+func LastRunFile(hapfile string) string {
+	return filepath.Join(filepath.Dir(hapfile), ".hap", "last-run.json")
+}
+
+// NewLastRun constructs a new last run from the args and results
+func NewLastRun(args []string, results Results) LastRun {
+	lr := LastRun{Args: args, Hosts: []LastHost{}}
+	for _, r := range results {
+		h := LastHost{Stage: r.Stage, Host: r.Host, RunOnce: r.RunOnce, Status: r.Status}
+		if r.Err != nil {
+			h.Error = Mask(r.Err.Error())
+		}
+		lr.Hosts = append(lr.Hosts, h)
+	}
+	return lr
+}
+
+// ReadLastRun reads the last run from a file
+func ReadLastRun(file string) (LastRun, error) {
+	var lr LastRun
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return lr, err
+	}
+	err = json.Unmarshal(b, &lr)
+	return lr, err
+}
+
+// Write saves the last run to a file
+func (lr LastRun) Write(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(lr, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, b, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(file, 0600)
+}
+
+// Failed returns the hosts of the stage that failed, were unreachable or
+// were not attempted. The results of the run-once cmds are not included.
+func (lr LastRun) Failed(stage string) map[string]bool {
+	failed := map[string]bool{}
+	for _, h := range lr.Hosts {
+		if h.Stage == stage && !h.RunOnce && h.failed() {
+			failed[h.Host] = true
+		}
+	}
+	return failed
+}
+
+// HasFailed returns whether any host or run-once cmds have to run again
+func (lr LastRun) HasFailed() bool {
+	for _, h := range lr.Hosts {
+		if h.failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// RunOnceFailed returns whether the run-once cmds of the stage did not succeed
+func (lr LastRun) RunOnceFailed(stage string) bool {
+	for _, h := range lr.Hosts {
+		if h.Stage == stage && h.RunOnce && h.failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Ran returns whether the last run has results for the stage
+func (lr LastRun) Ran(stage string) bool {
+	for _, h := range lr.Hosts {
+		if h.Stage == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry keeps the hosts that failed in the stage of the last run
+// All the hosts of a stage that was not run are kept, and so are all the
+// hosts without a last run.
+func (lr *LastRun) Retry(hosts map[string]*Host, stage string) map[string]*Host {
+	if lr == nil || (stage != "" && !lr.Ran(stage)) {
+		return hosts
+	}
+	failed := lr.Failed(stage)
+	for name := range hosts {
+		if !failed[name] {
+			delete(hosts, name)
+		}
+	}
+	return hosts
+}
+
+// failed returns whether the host has to run again
+func (h LastHost) failed() bool {
+	return h.Status == Failed || h.Status == Unreachable || h.Status == NotAttempted
+}
@@ -0,0 +1,78 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLastRun(t *testing.T) {
+	results := Results{
+		{Host: "one", Status: Success},
+		{Host: "two", Status: Failed, Err: errors.New("[two] Process exited with status 1")},
+		{Host: "three", Status: Unreachable, Err: &ConnectError{"Failed to connect to 10.0.0.3:22"}},
+		{Host: "four", Status: NotAttempted, Err: ErrNotAttempted},
+		{Stage: "migrate", Host: "one", RunOnce: true, Status: Failed, Err: errors.New("[one] migrate failed")},
+		{Stage: "migrate", Host: "two", Status: NotAttempted, Err: ErrNotAttempted},
+		{Stage: "release", Host: "one", RunOnce: true, Status: Success},
+	}
+	file := LastRunFile("TestHapfile")
+	if file != ".hap/last-run.json" {
+		t.Error("Want: .hap/last-run.json Got:", file)
+	}
+	if err := NewLastRun([]string{"c", "uptime"}, results).Write(file); err != nil {
+		t.Error(err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Error("Want: mode 0600 Got:", info, err)
+	}
+	lr, err := ReadLastRun(file)
+	if err != nil {
+		t.Error(err)
+	}
+	w1 := []string{"c", "uptime"}
+	if !reflect.DeepEqual(w1, lr.Args) {
+		t.Error("Want:", w1, "Got:", lr.Args)
+	}
+	w2 := map[string]bool{"two": true, "three": true, "four": true}
+	if g2 := lr.Failed(""); !reflect.DeepEqual(w2, g2) {
+		t.Error("Want:", w2, "Got:", g2)
+	}
+	w3 := map[string]bool{"two": true}
+	if g3 := lr.Failed("migrate"); !reflect.DeepEqual(w3, g3) {
+		t.Error("Want:", w3, "Got:", g3)
+	}
+	if !lr.RunOnceFailed("migrate") || lr.RunOnceFailed("release") || lr.RunOnceFailed("") {
+		t.Error("Want: only the run-once of migrate failed")
+	}
+	if g := lr.Failed("release"); len(g) != 0 {
+		t.Error("Want: no hosts Got:", g)
+	}
+	if !lr.Ran("release") || lr.Ran("cleanup") || !lr.HasFailed() {
+		t.Error("Want: release ran, cleanup did not and hosts failed")
+	}
+	if (LastRun{Hosts: []LastHost{{Host: "one", RunOnce: true, Status: Success}}}).HasFailed() {
+		t.Error("Want: nothing failed")
+	}
+	hosts := map[string]*Host{"one": &Host{}, "two": &Host{}, "three": &Host{}}
+	w4 := []string{"three", "two"}
+	if g4 := sortedNames(lr.Retry(hosts, "")); !reflect.DeepEqual(w4, g4) {
+		t.Error("Want:", w4, "Got:", g4)
+	}
+	w5 := []string{"one", "two"}
+	if g5 := sortedNames(lr.Retry(map[string]*Host{"one": &Host{}, "two": &Host{}}, "cleanup")); !reflect.DeepEqual(w5, g5) {
+		t.Error("Want:", w5, "Got:", g5)
+	}
+	var none *LastRun
+	if g5 := sortedNames(none.Retry(map[string]*Host{"one": &Host{}, "two": &Host{}}, "")); !reflect.DeepEqual(w5, g5) {
+		t.Error("Want:", w5, "Got:", g5)
+	}
+	if err := os.RemoveAll(".hap"); err != nil {
+		t.Error(err)
+	}
+}
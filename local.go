@@ -0,0 +1,27 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// RunLocal runs the commands in order on the local machine
+// Each command runs with `sh -c` and the env added to the current environment.
+// The first command that fails stops the rest.
+func RunLocal(cmds []string, env []string) error {
+	for _, c := range cmds {
+		cmd := exec.Command("sh", "-c", c)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout = NewRemoteWriter("local", os.Stdout)
+		cmd.Stderr = NewRemoteWriter("local", os.Stderr)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("[local] `%s` failed: %s", c, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRunLocal(t *testing.T) {
+	cmds := []string{"echo $HAP_COMMAND-$HAP_RESULT > TestLocalOutput"}
+	env := []string{"HAP_COMMAND=deploy", "HAP_RESULT=success"}
+	if err := RunLocal(cmds, env); err != nil {
+		t.Error(err)
+	}
+	b, err := ioutil.ReadFile("TestLocalOutput")
+	if err != nil {
+		t.Error(err)
+	}
+	ws := "deploy-success\n"
+	gs := string(b)
+	if ws != gs {
+		t.Error("Want:", ws, "Got:", gs)
+	}
+	err = os.Remove("TestLocalOutput")
+	if err != nil {
+		t.Error(err)
+	}
+	cmds = []string{"exit 1", "touch TestLocalOutput"}
+	if err := RunLocal(cmds, nil); err == nil {
+		t.Error("Expected error for failed command")
+	}
+	if _, err := os.Stat("TestLocalOutput"); err == nil {
+		t.Error("Expected commands after a failure to be skipped")
+		os.Remove("TestLocalOutput")
+	}
+}
@@ -0,0 +1,182 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"strings"
+)
+
+// Merge modes of the list settings
+const (
+	Append   = "append"
+	Override = "override"
+)
+
+// MergeModes are the modes of the list settings when a section is merged
+// onto a more general one. Settings are merged from [env], default,
+// inherited templates or hosts, the host, and then the deploy. A section
+// changes the mode of a list with append = <key> or override = <key>.
+var MergeModes = map[string]string{
+	"env":          Append,
+	"var":          Append,
+	"file":         Append,
+	"on-failure":   Append,
+	"always":       Append,
+	"local-before": Append,
+	"local-after":  Append,
+	"build":        Override,
+	"cmd":          Override,
+	"tag":          Override,
+}
+
+// MergeHost returns the child merged onto the parent
+// Settings of the child override the parent and confirm is kept if
+// either sets it. Lists are merged with the mode of the key: append
+// adds the child's list after the parent's, override uses the parent's
+// list only when the child's is empty. A key named in the child's
+// override always uses the child's list, even when it is empty.
+func MergeHost(parent, child *Host) *Host {
+	h := *child
+	h.Confirm = child.Confirm || parent.Confirm
+	p := *parent
+	scalars := p.scalars()
+	for key, dst := range h.scalars() {
+		if *dst == "" {
+			*dst = *scalars[key]
+		}
+	}
+	lists := p.lists()
+	for key, dst := range h.lists() {
+		switch child.mode(key) {
+		case Append:
+			if len(*lists[key]) > 0 {
+				*dst = append(append([]string{}, *lists[key]...), *dst...)
+			}
+		case Override:
+			if len(*dst) == 0 && !contains(lower(child.Override), key) {
+				*dst = *lists[key]
+			}
+		}
+	}
+	return &h
+}
+
+// inheritHost returns the child merged onto the host or template it inherits
+// The merge modes of the parent are kept unless the child changes them, so
+// they still apply when the result is merged onto the defaults.
+func inheritHost(parent, child *Host) *Host {
+	h := MergeHost(parent, child)
+	h.Append = inheritModes(parent.Append, child.Append, child.Override)
+	h.Override = inheritModes(parent.Override, child.Override, child.Append)
+	return h
+}
+
+// inheritModes returns the keys of the child followed by the keys of the
+// parent that the child does not name in either mode
+func inheritModes(parent, child, other []string) []string {
+	keys := append([]string{}, child...)
+	for _, key := range lower(parent) {
+		if !contains(lower(keys), key) && !contains(lower(other), key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// mode returns the merge mode of a list setting of the host
+func (h *Host) mode(key string) string {
+	if contains(lower(h.Append), key) {
+		return Append
+	}
+	if contains(lower(h.Override), key) {
+		return Override
+	}
+	return MergeModes[key]
+}
+
+// scalars returns the settings that hold one value by key
+func (h *Host) scalars() map[string]*string {
+	return map[string]*string{
+		"addr":     &h.Addr,
+		"dir":      &h.Dir,
+		"username": &h.Username,
+		"identity": &h.Identity,
+		"password": &h.Password,
+	}
+}
+
+// lists returns the settings that hold a list by key
+func (h *Host) lists() map[string]*[]string {
+	return map[string]*[]string{
+		"env":          &h.Env,
+		"var":          &h.Var,
+		"file":         &h.File,
+		"on-failure":   &h.OnFailure,
+		"always":       &h.Always,
+		"local-before": &h.LocalBefore,
+		"local-after":  &h.LocalAfter,
+		"build":        &h.Build,
+		"cmd":          &h.Cmd,
+		"tag":          &h.Tag,
+	}
+}
+
+// lower returns the values in lower case
+func lower(values []string) []string {
+	results := []string{}
+	for _, v := range values {
+		results = append(results, strings.ToLower(strings.TrimSpace(v)))
+	}
+	return results
+}
+
+// defaults returns the [env] files merged with the default section
+// Every host is merged onto the defaults.
+func (hf Hapfile) defaults() *Host {
+	d := Host(hf.Default)
+	return MergeHost(&Host{Env: hf.Env.File}, &d)
+}
+
+// deployParent returns the host to merge a deploy onto
+// The build and cmd of the host are replaced by the defaults' unless the
+// deploy names them in append, so a deploy only runs the builds it lists.
+func deployParent(host, defaults *Host, d *Deploy) *Host {
+	h := *host
+	lists, general := h.lists(), defaults.lists()
+	for _, key := range []string{"build", "cmd"} {
+		if !contains(lower(d.Append), key) {
+			*lists[key] = *general[key]
+		}
+	}
+	return &h
+}
+
+// host returns the settings of the deploy to merge onto each of its hosts
+func (d *Deploy) host() *Host {
+	return &Host{
+		Env:         d.Env,
+		Var:         d.Var,
+		File:        d.File,
+		Build:       d.Build,
+		Cmd:         d.Cmd,
+		Confirm:     d.Confirm,
+		OnFailure:   d.OnFailure,
+		Always:      d.Always,
+		LocalBefore: d.LocalBefore,
+		LocalAfter:  d.LocalAfter,
+		Append:      d.Append,
+		Override:    d.Override,
+	}
+}
+
+// LocalHooks returns the local-before and local-after cmds for the deploy
+// Without a deploy the cmds of the default section are returned.
+func (hf Hapfile) LocalHooks(deploy string) ([]string, []string) {
+	h := Host(hf.Default)
+	if d, ok := hf.Deploys[deploy]; ok {
+		h = *MergeHost(&h, d.host())
+	}
+	return h.LocalBefore, h.LocalAfter
+}
@@ -0,0 +1,235 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMergeHost(t *testing.T) {
+	parent := &Host{
+		Username: "deploy",
+		Identity: "~/.ssh/deploy",
+		Build:    []string{"init"},
+		Cmd:      []string{"echo parent"},
+		Env:      []string{"common"},
+		Tag:      []string{"web"},
+	}
+	tests := []struct {
+		child *Host
+		get   func(*Host) []string
+		want  []string
+	}{
+		{&Host{}, func(h *Host) []string { return h.Build }, []string{"init"}},
+		{&Host{Build: []string{"app"}}, func(h *Host) []string { return h.Build }, []string{"app"}},
+		{&Host{Build: []string{"app"}, Append: []string{"build"}}, func(h *Host) []string { return h.Build }, []string{"init", "app"}},
+		{&Host{Env: []string{"app"}}, func(h *Host) []string { return h.Env }, []string{"common", "app"}},
+		{&Host{Env: []string{"app"}, Override: []string{"env"}}, func(h *Host) []string { return h.Env }, []string{"app"}},
+		{&Host{Override: []string{"Cmd"}}, func(h *Host) []string { return h.Cmd }, nil},
+		{&Host{}, func(h *Host) []string { return h.Tag }, []string{"web"}},
+		{&Host{}, func(h *Host) []string { return []string{h.Username, h.Identity} }, []string{"deploy", "~/.ssh/deploy"}},
+		{&Host{Username: "admin"}, func(h *Host) []string { return []string{h.Username, h.Identity} }, []string{"admin", "~/.ssh/deploy"}},
+	}
+	for i, test := range tests {
+		got := test.get(MergeHost(parent, test.child))
+		if !reflect.DeepEqual(test.want, got) {
+			t.Error("Test:", i, "Want:", test.want, "Got:", got)
+		}
+	}
+	if h := MergeHost(&Host{Confirm: true}, &Host{}); !h.Confirm {
+		t.Error("Expected confirm from the parent")
+	}
+}
+
+func TestNewHapfileMerge(t *testing.T) {
+	builds := `
+[build "init"]
+cmd = echo init
+
+[build "app"]
+cmd = echo app`
+	tests := []struct {
+		name    string
+		cfg     string
+		include string
+		get     func(hf Hapfile) []string
+		want    []string
+	}{
+		{
+			"default build",
+			"[default]\nbuild = init\n[host \"one\"]\naddr = one",
+			"",
+			func(hf Hapfile) []string { return hf.Host("one").Cmds() },
+			[]string{"echo init"},
+		},
+		{
+			"host build overrides default",
+			"[default]\nbuild = init\n[host \"one\"]\naddr = one\nbuild = app",
+			"",
+			func(hf Hapfile) []string { return hf.Host("one").Cmds() },
+			[]string{"echo app"},
+		},
+		{
+			"host build appends to default",
+			"[default]\nbuild = init\n[host \"one\"]\naddr = one\nbuild = app\nappend = build",
+			"",
+			func(hf Hapfile) []string { return hf.Host("one").Cmds() },
+			[]string{"echo init", "echo app"},
+		},
+		{
+			"template build appends to default",
+			"[default]\nbuild = init\n[template \"t\"]\nbuild = app\nappend = build\n[host \"one\"]\naddr = one\ninherit = t",
+			"",
+			func(hf Hapfile) []string { return hf.Host("one").Cmds() },
+			[]string{"echo init", "echo app"},
+		},
+		{
+			"template env overrides default",
+			"[env]\nfile = global\n[default]\nenv = default\n[template \"t\"]\nenv = t\noverride = env\n[host \"one\"]\naddr = one\ninherit = t\nenv = a",
+			"",
+			func(hf Hapfile) []string { return hf.Host("one").Env },
+			[]string{"t", "a"},
+		},
+		{
+			"host cmd overrides default with nothing",
+			"[default]\ncmd = echo default\n[host \"one\"]\naddr = one\noverride = cmd",
+			"",
+			func(hf Hapfile) []string { return hf.Host("one").Cmds() },
+			[]string{},
+		},
+		{
+			"env appends in order",
+			"[env]\nfile = global\n[default]\nenv = default\n[host \"one\"]\naddr = one\nenv = a\nenv = b",
+			"",
+			func(hf Hapfile) []string { return hf.Host("one").Env },
+			[]string{"global", "default", "a", "b"},
+		},
+		{
+			"host env overrides",
+			"[env]\nfile = global\n[default]\nenv = default\n[host \"one\"]\naddr = one\nenv = a\noverride = env",
+			"",
+			func(hf Hapfile) []string { return hf.Host("one").Env },
+			[]string{"a"},
+		},
+		{
+			"deploy replaces host build",
+			"[host \"one\"]\naddr = one\nbuild = app\n[deploy \"d\"]\nhost = one\ncmd = echo deploy",
+			"",
+			func(hf Hapfile) []string { return hf.DeployHost("d", "one").Cmds() },
+			[]string{"echo deploy"},
+		},
+		{
+			"deploy uses default build",
+			"[default]\nbuild = init\n[host \"one\"]\naddr = one\nbuild = app\n[deploy \"d\"]\nhost = one\ncmd = echo deploy",
+			"",
+			func(hf Hapfile) []string { return hf.DeployHost("d", "one").Cmds() },
+			[]string{"echo init", "echo deploy"},
+		},
+		{
+			"deploy appends to host build",
+			"[host \"one\"]\naddr = one\nbuild = app\n[deploy \"d\"]\nhost = one\ncmd = echo deploy\nappend = build",
+			"",
+			func(hf Hapfile) []string { return hf.DeployHost("d", "one").Cmds() },
+			[]string{"echo app", "echo deploy"},
+		},
+		{
+			"deploy build overrides host",
+			"[host \"one\"]\naddr = one\nbuild = app\n[deploy \"d\"]\nhost = one\nbuild = init",
+			"",
+			func(hf Hapfile) []string { return hf.DeployHost("d", "one").Cmds() },
+			[]string{"echo init"},
+		},
+		{
+			"deploy build appends to host",
+			"[host \"one\"]\naddr = one\nbuild = app\n[deploy \"d\"]\nhost = one\nbuild = init\nappend = build",
+			"",
+			func(hf Hapfile) []string { return hf.DeployHost("d", "one").Cmds() },
+			[]string{"echo app", "echo init"},
+		},
+		{
+			"deploy without builds",
+			"[default]\nbuild = init\n[host \"one\"]\naddr = one\n[deploy \"d\"]\nhost = one\ncmd = echo deploy\noverride = build",
+			"",
+			func(hf Hapfile) []string { return hf.DeployHost("d", "one").Cmds() },
+			[]string{"echo deploy"},
+		},
+		{
+			"deploy env appends",
+			"[env]\nfile = global\n[host \"one\"]\naddr = one\nenv = host\n[deploy \"d\"]\nhost = one\nenv = deploy",
+			"",
+			func(hf Hapfile) []string { return hf.DeployHost("d", "one").Env },
+			[]string{"global", "host", "deploy"},
+		},
+		{
+			"deploy env overrides",
+			"[env]\nfile = global\n[host \"one\"]\naddr = one\nenv = host\n[deploy \"d\"]\nhost = one\nenv = deploy\noverride = env",
+			"",
+			func(hf Hapfile) []string { return hf.DeployHost("d", "one").Env },
+			[]string{"deploy"},
+		},
+		{
+			"deploy local hooks append to default",
+			"[default]\nlocal-before = make test\n[deploy \"d\"]\nlocal-before = make assets",
+			"",
+			func(hf Hapfile) []string { before, _ := hf.LocalHooks("d"); return before },
+			[]string{"make test", "make assets"},
+		},
+		{
+			"include env comes first",
+			"[include]\npath = TestAnotherHapfile\n[env]\nfile = main\n[host \"one\"]\naddr = one",
+			"[env]\nfile = included",
+			func(hf Hapfile) []string { return hf.Host("one").Env },
+			[]string{"included", "main"},
+		},
+		{
+			"include default merges",
+			"[include]\npath = TestAnotherHapfile\n[default]\nusername = main\nenv = main\n[host \"one\"]\naddr = one",
+			"[default]\nusername = included\nidentity = included\nenv = included\nbuild = init",
+			func(hf Hapfile) []string {
+				h := hf.Host("one")
+				return append([]string{h.Username, h.Identity}, append(h.Env, h.Cmds()...)...)
+			},
+			[]string{"main", "included", "included", "main", "echo init"},
+		},
+		{
+			"include host is overridden",
+			"[include]\npath = TestAnotherHapfile\n[host \"one\"]\naddr = main",
+			"[host \"one\"]\naddr = included\nusername = included",
+			func(hf Hapfile) []string { return []string{hf.Host("one").Addr, hf.Host("one").Username} },
+			[]string{"main", ""},
+		},
+	}
+	for _, test := range tests {
+		err := ioutil.WriteFile("TestHapfile", []byte(test.cfg+"\n"+builds), 0666)
+		if err != nil {
+			t.Error(err)
+		}
+		if test.include != "" {
+			err = ioutil.WriteFile("TestAnotherHapfile", []byte(test.include), 0666)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		hf, err := NewHapfile("TestHapfile")
+		if err != nil {
+			t.Error(test.name, err)
+			continue
+		}
+		if got := test.get(hf); !reflect.DeepEqual(test.want, got) {
+			t.Error(test.name, "Want:", test.want, "Got:", got)
+		}
+	}
+	err := os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	err = os.Remove("TestAnotherHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,77 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// OverlayDir holds the overlays in the repo
+// Files in overlays/tag/<tag>/ and overlays/host/<name>/ are copied
+// on top of the tree of the hosts with the tag or name.
+const OverlayDir = "overlays"
+
+// OverlayFile lists the files written by the overlays on the remote
+const OverlayFile = ".hap-overlay"
+
+// Overlay is a file copied from an overlay to the tree
+type Overlay struct {
+	Src  string
+	Dest string
+}
+
+// Overlays returns the files of the overlays of the host
+// The tag overlays are applied in the order of the tags, then the host
+// overlay. A file in a later overlay replaces the same file in an earlier one.
+func Overlays(files []string, h *Host) []Overlay {
+	dirs := []string{}
+	for _, tag := range h.Tag {
+		dirs = append(dirs, path.Join(OverlayDir, "tag", tag)+"/")
+	}
+	dirs = append(dirs, path.Join(OverlayDir, "host", h.Name)+"/")
+	overlays := []Overlay{}
+	index := map[string]int{}
+	for _, dir := range dirs {
+		for _, file := range files {
+			if !strings.HasPrefix(file, dir) {
+				continue
+			}
+			o := Overlay{file, strings.TrimPrefix(file, dir)}
+			if i, ok := index[o.Dest]; ok {
+				overlays[i] = o
+				continue
+			}
+			index[o.Dest] = len(overlays)
+			overlays = append(overlays, o)
+		}
+	}
+	return overlays
+}
+
+// overlayScript applies the overlays in the dir
+// The files of the previous overlays are restored from git, or removed
+// if git does not track them, so no file is left behind.
+func overlayScript(dir string, overlays []Overlay) string {
+	lines := []string{
+		"cd " + dir + " || exit 1",
+		"grep -qx " + OverlayFile + " .git/info/exclude 2>/dev/null || echo " + OverlayFile + " >> .git/info/exclude",
+		"if [ -f " + OverlayFile + " ]; then",
+		"while IFS= read -r f; do",
+		"if git ls-files --error-unmatch -- \"$f\" >/dev/null 2>&1; then git checkout -q -- \"$f\"; else rm -f \"$f\"; fi",
+		"done < " + OverlayFile,
+		"rm -f " + OverlayFile,
+		"fi",
+	}
+	for _, o := range overlays {
+		src, dest := shellQuote(o.Src), shellQuote(o.Dest)
+		lines = append(lines, fmt.Sprintf(
+			"mkdir -p \"$(dirname %s)\" && rm -f %s && cp -p %s %s && echo %s >> %s || exit 1",
+			dest, dest, src, dest, dest, OverlayFile,
+		))
+	}
+	return strings.Join(lines, "\n")
+}
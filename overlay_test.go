@@ -0,0 +1,93 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOverlays(t *testing.T) {
+	files := []string{
+		"overlays/host/one/extra.conf",
+		"overlays/host/two/app.conf",
+		"overlays/tag/db/app.conf",
+		"overlays/tag/web/app.conf",
+		"overlays/tag/web/extra.conf",
+		"overlays/tag/web2/app.conf",
+		"app.conf",
+	}
+	h := &Host{Name: "one", Tag: []string{"web", "db"}}
+	w := []Overlay{
+		{"overlays/tag/db/app.conf", "app.conf"},
+		{"overlays/host/one/extra.conf", "extra.conf"},
+	}
+	g := Overlays(files, h)
+	if !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+}
+
+func TestOverlayScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hap-overlay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"app.conf":                     "base",
+		"overlays/tag/web/app.conf":    "web",
+		"overlays/host/one/conf/x.env": "one",
+	}
+	for file, content := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(file)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=hap", "-c", "user.email=hap@localhost", "commit", "-q", "-m", "overlays"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatal(string(out), err)
+		}
+	}
+	committed, err := Git{Work: dir}.Files("HEAD", OverlayDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(file string) string {
+		b, _ := ioutil.ReadFile(filepath.Join(dir, file))
+		return string(b)
+	}
+	h := &Host{Name: "one", Tag: []string{"web"}}
+	if out, err := exec.Command("sh", "-c", overlayScript(dir, Overlays(committed, h))).CombinedOutput(); err != nil {
+		t.Fatal(string(out), err)
+	}
+	w := map[string]string{"app.conf": "web", "conf/x.env": "one", OverlayFile: "app.conf\nconf/x.env\n"}
+	for file, content := range w {
+		if g := read(file); g != content {
+			t.Error("Want:", content, "Got:", g)
+		}
+	}
+
+	if out, err := exec.Command("sh", "-c", overlayScript(dir, nil)).CombinedOutput(); err != nil {
+		t.Fatal(string(out), err)
+	}
+	if g := read("app.conf"); g != "base" {
+		t.Error("Want: base Got:", g)
+	}
+	for _, file := range []string{"conf/x.env", OverlayFile} {
+		if _, err := os.Stat(filepath.Join(dir, file)); !os.IsNotExist(err) {
+			t.Error("Want:", file, "removed Got:", err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package hap
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -23,14 +24,31 @@ import (
 // Formatted script that checks if the build happened.
 const happened string = "if [ \"$(git rev-parse HEAD)\" = \"$(cat .happended)\" ]; then echo \"Already completed. Commit again?\"; exit 2; fi"
 
+// ErrHappened is returned by Build when the current commit was already built
+var ErrHappened = errors.New("already completed")
+
+// ConnectError is returned when the remote machine can not be reached
+type ConnectError struct {
+	msg string
+}
+
+// Error implements the error interface
+func (e *ConnectError) Error() string {
+	return e.msg
+}
+
 // Remote defines the remote machine to provision
 type Remote struct {
 	Git        Git
 	Dir        string
 	Host       *Host
+	Secrets    map[string]string
+	Files      []Rendered
+	Ref        string
 	env        []string
 	gitSSHFile string
 	sshConfig  SSHConfig
+	client     *ssh.Client
 	session    *ssh.Session
 	writer     io.Writer
 }
@@ -65,35 +83,45 @@ func NewRemote(host *Host) (*Remote, error) {
 }
 
 // Connect starts an ssh session to a remote machine
+// The connection is made once and each session is opened on it.
 func (r *Remote) Connect() error {
 	if r.session != nil {
 		return nil
 	}
-	var client *ssh.Client
-	var err error
-	for range r.sshConfig.ClientConfig.Auth {
-		client, err = ssh.Dial("tcp", r.sshConfig.Addr, r.sshConfig.ClientConfig)
-		if err != nil {
-			if len(r.sshConfig.ClientConfig.Auth) == 1 {
-				return fmt.Errorf("Failed to connect with username=%s identity=%s password=%s",
-					r.sshConfig.Username, r.sshConfig.Identity, r.sshConfig.Password)
-			}
-			r.sshConfig.ClientConfig.Auth = r.sshConfig.ClientConfig.Auth[1:]
+	if r.client == nil {
+		if err := r.dial(); err != nil {
+			return err
 		}
 	}
-	if client == nil {
-		return fmt.Errorf("Failed to connect to %s", r.Host.Addr)
-	}
-	session, err := client.NewSession()
+	session, err := r.client.NewSession()
 	if err != nil {
-		return fmt.Errorf("Failed to create session: %s", err)
+		return &ConnectError{fmt.Sprintf("Failed to create session: %s", err)}
 	}
 	r.session = session
 	return nil
 }
 
-// Close ends an ssh session with a remote machine
-func (r *Remote) Close() error {
+// dial connects to the remote machine, dropping the first auth method
+// after each failed attempt
+func (r *Remote) dial() error {
+	config := *r.sshConfig.ClientConfig
+	for len(config.Auth) > 0 {
+		client, err := ssh.Dial("tcp", r.sshConfig.Addr, &config)
+		if err == nil {
+			r.client = client
+			return nil
+		}
+		if len(config.Auth) == 1 {
+			return &ConnectError{fmt.Sprintf("Failed to connect with username=%s identity=%s: %s",
+				r.sshConfig.Username, r.sshConfig.Identity, err)}
+		}
+		config.Auth = config.Auth[1:]
+	}
+	return &ConnectError{fmt.Sprintf("Failed to connect to %s", r.Host.Addr)}
+}
+
+// closeSession ends the ssh session and keeps the connection
+func (r *Remote) closeSession() error {
 	if r.session != nil {
 		err := r.session.Close()
 		r.session = nil
@@ -102,6 +130,18 @@ func (r *Remote) Close() error {
 	return nil
 }
 
+// Close ends the ssh session and the connection with a remote machine
+func (r *Remote) Close() error {
+	err := r.closeSession()
+	if r.client != nil {
+		if cerr := r.client.Close(); err == nil {
+			err = cerr
+		}
+		r.client = nil
+	}
+	return err
+}
+
 // Initialize sets up a git repo on the remote machine
 func (r *Remote) Initialize() error {
 	if err := r.Connect(); err != nil {
@@ -121,26 +161,63 @@ func (r *Remote) Initialize() error {
 }
 
 // Push updates the repo on the remote machine
+// With a Ref, the commit of the ref is pushed to the happened branch
+// instead of the current branch, so the local checkout is not changed.
+// The ref and its commit are recorded in .hap-ref on the remote.
 func (r *Remote) Push() error {
 	if err := r.Connect(); err != nil {
 		return err
 	}
-	b, err := r.Git.RevParse()
+	ref := r.Ref
+	if ref == "" {
+		b, err := r.Git.RevParse()
+		if err != nil {
+			return fmt.Errorf("%s\n%s", string(b), err)
+		}
+		ref = strings.TrimSpace(string(b))
+	}
+	commit, err := r.Git.Resolve(ref)
 	if err != nil {
-		return fmt.Errorf("%s\n%s", string(b), err)
+		return err
 	}
-	branch := strings.TrimSpace(string(b))
-	if branch == "HEAD" {
-		branch = fmt.Sprintf("%s:refs/heads/happened", branch)
+	branch, refspec := ref, ref
+	if r.Ref != "" || ref == "HEAD" {
+		branch = "happened"
+		refspec = fmt.Sprintf("%s:refs/heads/happened", commit)
 	}
 	if err := r.Initialize(); err != nil {
 		return fmt.Errorf("%s", err)
 	}
 	os.Setenv("GIT_SSH", r.gitSSHFile)
-	if output, err := r.Git.Push(branch); err != nil {
+	if output, err := r.Git.Push(refspec); err != nil {
 		return fmt.Errorf("%s\n%s", string(output), err)
 	}
-	return nil
+	return r.ExecuteScript(refScript(r.Dir, branch, ref, commit))
+}
+
+// refScript records the pushed ref and commit in .hap-ref
+// The branch is checked out if the push did not change the commit, so
+// the post-receive hook did not run.
+func refScript(dir, branch, ref, commit string) string {
+	return strings.Join([]string{
+		"cd " + dir + " || exit 1",
+		fmt.Sprintf("[ \"$(git rev-parse HEAD)\" = %s ] || { git reset -q --hard && git checkout -q %s; } || exit 1", commit, shellQuote(branch)),
+		"echo " + shellQuote(ref+" "+commit) + " > .hap-ref",
+		"grep -qx .hap-ref .git/info/exclude 2>/dev/null || echo .hap-ref >> .git/info/exclude",
+	}, "\n")
+}
+
+// Overlay applies the overlays of the host on top of the pushed tree
+func (r *Remote) Overlay() error {
+	ref := r.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	files, err := r.Git.Files(ref, OverlayDir)
+	if err != nil {
+		return err
+	}
+	return r.ExecuteScript(overlayScript(r.Dir, Overlays(files, r.Host)))
 }
 
 // PushSubmodules runs Push() to put submodules
@@ -166,11 +243,13 @@ func (r *Remote) PushSubmodules() error {
 				sshConfig:  r.sshConfig,
 				Dir:        filepath.Join(r.Dir, module.Path),
 				Host:       r.Host,
+				Secrets:    r.Secrets,
 				Git: Git{
 					Repo: fmt.Sprint(r.Git.Repo, "/", module.Path),
 					Work: module.Path,
 				},
 			}
+			defer sr.Close()
 			if err := sr.Push(); err != nil {
 				errors = append(errors, fmt.Sprintf("[%s] %s", module.Path, err))
 			}
@@ -184,25 +263,114 @@ func (r *Remote) PushSubmodules() error {
 }
 
 // Build executes the builds and cmds
-// First execute builds specified in Hapfile
+// First deliver the rendered files
+// Then execute builds specified in Hapfile
 // Then execute any cmds specified in Hapfile
+// Unless forced, ErrHappened is returned if the commit was already built.
 func (r *Remote) Build(force bool) error {
 	cmds := []string{"cd " + r.Dir, "HAP_DIR=`pwd`", "touch .happended"}
 	if !force {
-		cmds = append(cmds, happened)
+		err := r.Execute(append(cmds, happened))
+		var exit *ssh.ExitError
+		if errors.As(err, &exit) && exit.ExitStatus() == 2 {
+			return ErrHappened
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := r.Deliver(r.Files); err != nil {
+		return err
 	}
 	cmds = r.Host.AddEnv(cmds)
 	cmds = append(cmds, r.Host.Cmds()...)
 	cmds = append(cmds, "cd $HAP_DIR; echo `git rev-parse HEAD` > .happended")
-	return r.Execute(cmds)
+	failure, always := r.Host.FailureCmds(), r.Host.AlwaysCmds()
+	if len(failure) == 0 && len(always) == 0 {
+		return r.Execute(cmds)
+	}
+	return r.ExecuteScript(handlerScript(cmds, failure, always))
+}
+
+// handlerScript runs the cmds in order until one fails
+// After a failure the failure cmds run, then the always cmds run.
+// Both can use HAP_FAILED_CMD and HAP_EXIT_CODE.
+// The script exits with the code of the failed cmd.
+func handlerScript(cmds, failure, always []string) string {
+	lines := []string{"HAP_EXIT_CODE=0", "HAP_FAILED_CMD=\"\""}
+	for _, cmd := range cmds {
+		lines = append(lines,
+			"if [ \"$HAP_EXIT_CODE\" = 0 ]; then",
+			fmt.Sprintf("%s || { HAP_EXIT_CODE=$?; HAP_FAILED_CMD=%s; }", cmd, shellQuote(cmd)),
+			"fi",
+		)
+	}
+	lines = append(lines, "export HAP_EXIT_CODE HAP_FAILED_CMD", "cd $HAP_DIR 2>/dev/null")
+	if len(failure) > 0 {
+		lines = append(lines, "if [ \"$HAP_EXIT_CODE\" != 0 ]; then")
+		lines = append(lines, failure...)
+		lines = append(lines, "fi")
+	}
+	lines = append(lines, always...)
+	lines = append(lines, "exit $HAP_EXIT_CODE")
+	return strings.Join(lines, "\n")
+}
+
+// shellQuote wraps s in single quotes for the shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+// ExecuteScript runs a script on the remote machine
+// The script is sent to the shell on stdin so it needs no quoting,
+// and the commands of the script read their stdin from /dev/null.
+// The decrypted env files are set on stdin too, so they are never
+// written to the remote disk or shown in the process list.
+func (r *Remote) ExecuteScript(script string) error {
+	script, err := r.script(script)
+	if err != nil {
+		return err
+	}
+	if err := r.Connect(); err != nil {
+		return err
+	}
+	defer r.closeSession()
+	r.session.Stdout = NewRemoteWriter(r.Host.Name, os.Stdout)
+	r.session.Stderr = NewRemoteWriter(r.Host.Name, os.Stderr)
+	r.session.Stdin = strings.NewReader(script)
+	if err := r.session.Run("sh -s"); err != nil {
+		return fmt.Errorf("[%s] %w", r.Host.Name, err)
+	}
+	return nil
+}
+
+// script returns the script to send on stdin with the env and secrets
+func (r *Remote) script(script string) (string, error) {
+	secrets, err := r.secretEnv()
+	if err != nil {
+		return "", err
+	}
+	return stdinScript(fmt.Sprint(r.Env(), "\n", secrets, script)), nil
+}
+
+// stdinScript wraps a script sent to sh -s in a group reading /dev/null
+// The shell parses the whole group before running it, so a command that
+// reads stdin can not consume the rest of the script.
+func stdinScript(script string) string {
+	return "{\n" + script + "\n} </dev/null\n"
 }
 
 // Execute will shell out to run one or more commands
+// Hosts with encrypted env files run the commands as a script, which
+// reads stdin from /dev/null like the commands run with sh -c.
 func (r *Remote) Execute(commands []string) error {
+	if r.hasSecrets() {
+		return r.ExecuteScript(strings.Join(commands, " &&\n"))
+	}
 	if err := r.Connect(); err != nil {
 		return err
 	}
-	defer r.Close()
+	defer r.closeSession()
 	r.session.Stdout = NewRemoteWriter(r.Host.Name, os.Stdout)
 	r.session.Stderr = NewRemoteWriter(r.Host.Name, os.Stderr)
 	cmd := fmt.Sprint(r.Env(), commands[0])
@@ -210,7 +378,7 @@ func (r *Remote) Execute(commands []string) error {
 		cmd = fmt.Sprintf("sh -c '%s%s'", r.Env(), strings.Join(commands, "&&"))
 	}
 	if err := r.session.Run(cmd); err != nil {
-		return fmt.Errorf("[%s] %s", r.Host.Name, err)
+		return fmt.Errorf("[%s] %w", r.Host.Name, err)
 	}
 	return nil
 }
@@ -227,6 +395,79 @@ func (r *Remote) Env() string {
 	)
 }
 
+// Deliver writes the rendered files on the remote machine
+// Each file is sent on stdin to a temporary file that is moved into place.
+func (r *Remote) Deliver(files []Rendered) error {
+	for _, f := range files {
+		dest, tmp := shellQuote(f.Dest), shellQuote(f.Dest+".hap-new")
+		cmd := fmt.Sprintf(
+			"cd %s && mkdir -p \"$(dirname %s)\" && cat > %s && chmod %o %s && mv -f %s %s",
+			r.Dir, dest, tmp, f.Mode, tmp, tmp, dest,
+		)
+		if err := r.send(cmd, f.Content); err != nil {
+			return fmt.Errorf("%s\nfailed to write file %s", err, f.Name)
+		}
+	}
+	return nil
+}
+
+// Diff prints the changes between the deployed files and the rendered files
+// A file that does not exist yet is shown as new.
+func (r *Remote) Diff(files []Rendered) error {
+	for _, f := range files {
+		dest, label := shellQuote(f.Dest), shellQuote(f.Dest+" (rendered)")
+		cmd := fmt.Sprintf(
+			"cd %s 2>/dev/null; if [ -f %s ]; then diff -u -L %s -L %s %s -; else diff -u -L /dev/null -L %s /dev/null -; fi; [ $? -le 1 ]",
+			r.Dir, dest, dest, label, dest, label,
+		)
+		if err := r.send(cmd, f.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send runs the cmd on the remote machine with the content on stdin
+func (r *Remote) send(cmd string, content []byte) error {
+	if err := r.Connect(); err != nil {
+		return err
+	}
+	defer r.closeSession()
+	r.session.Stdout = NewRemoteWriter(r.Host.Name, os.Stdout)
+	r.session.Stderr = NewRemoteWriter(r.Host.Name, os.Stderr)
+	r.session.Stdin = bytes.NewReader(content)
+	if err := r.session.Run(cmd); err != nil {
+		return fmt.Errorf("[%s] %w", r.Host.Name, err)
+	}
+	return nil
+}
+
+// hasSecrets reports whether the host has encrypted env files
+func (r *Remote) hasSecrets() bool {
+	for _, file := range r.Host.Env {
+		if IsSecret(file) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretEnv sets the variables that hold the decrypted env files
+func (r *Remote) secretEnv() (string, error) {
+	env := ""
+	for i, file := range r.Host.Env {
+		if !IsSecret(file) {
+			continue
+		}
+		content, ok := r.Secrets[file]
+		if !ok {
+			return "", fmt.Errorf("[%s] encrypted env file '%s' was not decrypted", r.Host.Name, file)
+		}
+		env += secretVar(i) + "=" + shellQuote(content) + "\n"
+	}
+	return env, nil
+}
+
 // NewRemoteWriter returns a Writer with [host] prepended to the output
 func NewRemoteWriter(host string, w io.Writer) io.Writer {
 	return &RemoteWriter{host: host, w: w}
@@ -239,12 +480,13 @@ type RemoteWriter struct {
 }
 
 // Write implements the io.Writer interface
+// Secret values are masked in the output.
 func (hw *RemoteWriter) Write(p []byte) (int, error) {
 	var err error
 	l := len(p)
 	scanner := bufio.NewScanner(bytes.NewReader(p))
 	for scanner.Scan() {
-		_, err = fmt.Fprintf(hw.w, "[%s] %s\n", hw.host, scanner.Bytes())
+		_, err = fmt.Fprintf(hw.w, "[%s] %s\n", hw.host, Mask(scanner.Text()))
 	}
 	if err != nil {
 		return l, err
@@ -5,13 +5,112 @@
 package hap
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func TestRemoteConnect(t *testing.T) {
-
+	config := &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.Password("one"), ssh.Password("two")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	r := &Remote{
+		Host:      &Host{Name: "one", Addr: "127.0.0.1:1"},
+		sshConfig: SSHConfig{Addr: "127.0.0.1:1", Password: "s3cr3t", ClientConfig: config},
+	}
+	var ce *ConnectError
+	err := r.Connect()
+	if !errors.As(err, &ce) || strings.Contains(err.Error(), "s3cr3t") {
+		t.Error("Want: ConnectError without the password Got:", err)
+	}
+	if len(config.Auth) != 2 || r.client != nil || r.session != nil {
+		t.Error("Want: the shared config unchanged and no connection Got:", len(config.Auth), r.client, r.session)
+	}
+	if err := r.Close(); err != nil {
+		t.Error(err)
+	}
 }
 
 func TestRemoteInitialize(t *testing.T) {
 
 }
+
+func TestHandlerScript(t *testing.T) {
+	cmds := []string{"echo one", "test 'a' = 'b'", "echo two"}
+	failure := []string{"echo \"failed $HAP_FAILED_CMD $HAP_EXIT_CODE\""}
+	always := []string{"echo always"}
+	out, err := exec.Command("sh", "-c", handlerScript(cmds, failure, always)).Output()
+	if err == nil {
+		t.Error("Expected the script to exit with the failed code")
+	}
+	ws := "one\nfailed test 'a' = 'b' 1\nalways\n"
+	gs := string(out)
+	if ws != gs {
+		t.Error("Want:", ws, "Got:", gs)
+	}
+	out, err = exec.Command("sh", "-c", handlerScript([]string{"echo one"}, failure, always)).Output()
+	if err != nil {
+		t.Error(err)
+	}
+	ws = "one\nalways\n"
+	gs = string(out)
+	if ws != gs {
+		t.Error("Want:", ws, "Got:", gs)
+	}
+}
+
+func TestRefScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hap-ref")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=hap", "-c", "user.email=hap@localhost", "commit", "-q", "--allow-empty", "-m", "one"},
+		{"branch", "happened"},
+		{"-c", "user.name=hap", "-c", "user.email=hap@localhost", "commit", "-q", "--allow-empty", "-m", "two"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatal(string(out), err)
+		}
+	}
+	git := Git{Work: dir}
+	commit, _ := git.Resolve("happened")
+	if out, err := exec.Command("sh", "-c", refScript(dir, "happened", "v1", commit)).CombinedOutput(); err != nil {
+		t.Fatal(string(out), err)
+	}
+	if head, _ := git.Resolve("HEAD"); head != commit {
+		t.Error("Want:", commit, "Got:", head)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dir, ".hap-ref"))
+	if w, g := "v1 "+commit+"\n", string(b); w != g {
+		t.Error("Want:", w, "Got:", g)
+	}
+}
+
+func TestStdinScript(t *testing.T) {
+	shell, err := exec.LookPath("bash")
+	if err != nil {
+		shell = "sh"
+	}
+	script := handlerScript([]string{"echo one", "cat >/dev/null", "echo two"}, nil, []string{"echo always"})
+	cmd := exec.Command(shell, "-s")
+	cmd.Stdin = strings.NewReader(stdinScript(script))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Error(err)
+	}
+	if ws, gs := "one\ntwo\nalways\n", string(out); ws != gs {
+		t.Error("Want:", ws, "Got:", gs)
+	}
+}
@@ -0,0 +1,174 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/template"
+)
+
+// File is a template rendered for each host that lists it
+// Src is a local text/template, like nginx.conf.tmpl, relative to the
+// file that defines it, and Dest is the path on the host, relative to
+// the host dir. Mode defaults to 0644.
+type File struct {
+	Src  string
+	Dest string
+	Mode string
+}
+
+// hostFile is a file of a host with the name of its section
+// A relative Src is read from dir, the directory of the defining file.
+type hostFile struct {
+	name string
+	dir  string
+	File
+}
+
+// Rendered is a file rendered for a host
+type Rendered struct {
+	Name    string
+	Dest    string
+	Mode    os.FileMode
+	Content []byte
+}
+
+// TemplateData is what the files are rendered with
+// Vars has the interpolated vars of the host and Env has the HAP_*
+// variables, like {{.Env.HAP_IP}}. Use {{if tag "web"}} to check a tag.
+type TemplateData struct {
+	Name     string
+	Addr     string
+	Username string
+	Dir      string
+	Identity string
+	Tags     []string
+	Vars     map[string]string
+	Env      map[string]string
+}
+
+// checkFileNames reports files that are not defined
+func (hf Hapfile) checkFileNames() []error {
+	refs := map[string][]string{"default": hf.Default.File}
+	for n, h := range hf.Hosts {
+		refs["host "+n] = h.File
+	}
+	for n, d := range hf.Deploys {
+		refs["deploy "+n] = d.File
+	}
+	keys := []string{}
+	for key := range refs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	errs := []error{}
+	reported := map[string]bool{}
+	for _, key := range keys {
+		for _, name := range refs[key] {
+			f, ok := hf.Files[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("[%s] unknown file '%s'", key, name))
+			} else if (f.Src == "" || f.Dest == "") && !reported[name] {
+				reported[name] = true
+				errs = append(errs, fmt.Errorf("[file %s] expects src and dest", name))
+			}
+		}
+	}
+	return errs
+}
+
+// hostFiles returns the named files in order, each only once
+func (hf Hapfile) hostFiles(names []string) []hostFile {
+	files := []hostFile{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if f, ok := hf.Files[name]; ok && !seen[name] {
+			seen[name] = true
+			files = append(files, hostFile{name, filepath.Dir(hf.sources["file "+name]), *f})
+		}
+	}
+	return files
+}
+
+// interpolateVars returns the vars with the references replaced
+func interpolateVars(lookup func(string) (string, bool), entries []string) (map[string]string, error) {
+	parsed, err := ParseVars(entries)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	for name := range parsed {
+		if vars[name], err = Interpolate("${"+name+"}", lookup); err != nil {
+			return nil, err
+		}
+	}
+	return vars, nil
+}
+
+// hapEnv returns the HAP_* variables of the host
+func hapEnv(h *Host) map[string]string {
+	ip, port, _ := net.SplitHostPort(h.Addr)
+	return map[string]string{
+		"HAP_HOSTNAME": h.Name,
+		"HAP_ADDR":     h.Addr,
+		"HAP_USER":     h.Username,
+		"HAP_IP":       ip,
+		"HAP_PORT":     port,
+	}
+}
+
+// Render renders the files of the host
+// A var or field that is not defined is an error.
+func (h *Host) Render() ([]Rendered, error) {
+	data := TemplateData{
+		Name:     h.Name,
+		Addr:     h.Addr,
+		Username: h.Username,
+		Dir:      h.GetDir(),
+		Identity: h.Identity,
+		Tags:     h.Tag,
+		Vars:     h.vars,
+		Env:      hapEnv(h),
+	}
+	funcs := template.FuncMap{
+		"tag": func(tag string) bool { return contains(h.Tag, tag) },
+	}
+	results := []Rendered{}
+	for _, f := range h.files {
+		mode := os.FileMode(0644)
+		if f.Mode != "" {
+			m, err := strconv.ParseUint(f.Mode, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("[file %s] invalid mode '%s'", f.name, f.Mode)
+			}
+			mode = os.FileMode(m)
+		}
+		src := f.Src
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(f.dir, src)
+		}
+		b, err := ioutil.ReadFile(src)
+		if err != nil {
+			return nil, fmt.Errorf("[file %s] %s", f.name, err)
+		}
+		t, err := template.New(f.Src).Funcs(funcs).Option("missingkey=error").Parse(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("[file %s] %s", f.name, err)
+		}
+		var out bytes.Buffer
+		if err := t.Execute(&out, data); err != nil {
+			return nil, fmt.Errorf("[file %s] %s", f.name, err)
+		}
+		results = append(results, Rendered{f.name, f.Dest, mode, out.Bytes()})
+	}
+	return results, nil
+}
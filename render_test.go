@@ -0,0 +1,94 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	ioutil.WriteFile("TestNginx.tmpl", []byte(
+		"server {{.Name}} {{.Env.HAP_IP}}:{{.Vars.port}} {{.Dir}}{{if tag \"web\"}} web{{end}}\n",
+	), 0644)
+	defer os.Remove("TestNginx.tmpl")
+	ioutil.WriteFile("TestUnit.tmpl", []byte("[Service]\nUser={{.Username}}\n"), 0644)
+	defer os.Remove("TestUnit.tmpl")
+	ioutil.WriteFile("TestHapfile", []byte(`
+[vars]
+var = port=80
+
+[default]
+username = app
+file = unit
+
+[file "nginx"]
+src = TestNginx.tmpl
+dest = /etc/nginx/${host.name}.conf
+
+[file "unit"]
+src = TestUnit.tmpl
+dest = app.service
+mode = 0600
+
+[host "one"]
+addr = "10.0.0.1:22"
+dir = /srv/app
+tag = web
+var = port=8080
+file = nginx
+file = unit
+`), 0644)
+	defer os.Remove("TestHapfile")
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := hf.Host("one").Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := []Rendered{
+		{"unit", "app.service", 0600, []byte("[Service]\nUser=app\n")},
+		{"nginx", "/etc/nginx/one.conf", 0644, []byte("server one 10.0.0.1:8080 /srv/app web\n")},
+	}
+	if !reflect.DeepEqual(w, files) {
+		t.Error("Want:", w, "Got:", files)
+	}
+
+	ioutil.WriteFile("TestNginx.tmpl", []byte("{{.Vars.missing}}"), 0644)
+	if _, err := hf.Host("one").Render(); err == nil || !strings.HasPrefix(err.Error(), "[file nginx]") {
+		t.Error("Want: error for a missing var Got:", err)
+	}
+
+	defer os.RemoveAll("TestFiles")
+	os.MkdirAll("TestFiles", 0755)
+	ioutil.WriteFile("TestFiles/sub.tmpl", []byte("{{.Name}}"), 0644)
+	ioutil.WriteFile("TestFiles/Hapfile", []byte("[file \"sub\"]\nsrc = sub.tmpl\ndest = sub\n"), 0644)
+	ioutil.WriteFile("TestHapfile", []byte("[include]\npath = TestFiles/Hapfile\n[host \"one\"]\naddr = one\nfile = sub\n"), 0644)
+	if hf, err = NewHapfile("TestHapfile"); err != nil {
+		t.Fatal(err)
+	}
+	files, err = hf.Host("one").Render()
+	if err != nil || len(files) != 1 || string(files[0].Content) != "one" {
+		t.Error("Want: sub.tmpl read next to its Hapfile Got:", files, err)
+	}
+}
+
+func TestNewHapfileWithUnknownFile(t *testing.T) {
+	ioutil.WriteFile("TestHapfile", []byte(`
+[host "one"]
+addr = "10.0.0.1:22"
+file = missing
+`), 0644)
+	defer os.Remove("TestHapfile")
+	_, err := NewHapfile("TestHapfile")
+	if w := "[host one] unknown file 'missing'"; err == nil || err.Error() != w {
+		t.Error("Want:", w, "Got:", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ErrNotAttempted marks a host that was never run
+var ErrNotAttempted = errors.New("not attempted")
+
+// Status describes the outcome of a command on a host
+type Status string
+
+// The possible statuses of a host
+const (
+	Success      Status = "success"
+	Skipped      Status = "skipped"
+	Failed       Status = "failed"
+	Unreachable  Status = "unreachable"
+	NotAttempted Status = "not-attempted"
+)
+
+// StatusOf returns the status for the error returned by a command
+func StatusOf(err error) Status {
+	var ce *ConnectError
+	switch {
+	case err == nil:
+		return Success
+	case errors.Is(err, ErrHappened):
+		return Skipped
+	case errors.Is(err, ErrNotAttempted):
+		return NotAttempted
+	case errors.As(err, &ce):
+		return Unreachable
+	}
+	return Failed
+}
+
+// Result holds the outcome of a command on a host
+// RunOnce marks the result of the run-once cmds of a deploy.
+type Result struct {
+	Stage    string
+	Host     string
+	RunOnce  bool
+	Status   Status
+	Duration time.Duration
+	Err      error
+}
+
+// NewResult constructs a new result from the error returned by a command
+func NewResult(host string, start time.Time, err error) Result {
+	return Result{
+		Host:     host,
+		Status:   StatusOf(err),
+		Duration: time.Since(start),
+		Err:      err,
+	}
+}
+
+// Results is a list of results
+type Results []Result
+
+// Failed returns whether any host failed, was unreachable or was not attempted
+func (rs Results) Failed() bool {
+	for _, r := range rs {
+		if r.Status == Failed || r.Status == Unreachable || r.Status == NotAttempted {
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the hosts with any of the statuses
+func (rs Results) Names(statuses ...Status) []string {
+	names := []string{}
+	for _, r := range rs {
+		for _, s := range statuses {
+			if r.Status == s {
+				names = append(names, r.Host)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// Summary writes the results as a table
+func (rs Results) Summary(w io.Writer) error {
+	stages := false
+	for _, r := range rs {
+		if r.Stage != "" {
+			stages = true
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if stages {
+		fmt.Fprint(tw, "STAGE\t")
+	}
+	fmt.Fprintln(tw, "HOST\tSTATUS\tDURATION\tERROR")
+	for _, r := range rs {
+		msg := ""
+		if r.Err != nil {
+			msg = strings.SplitN(strings.TrimSpace(Mask(r.Err.Error())), "\n", 2)[0]
+		}
+		if stages {
+			fmt.Fprintf(tw, "%s\t", r.Stage)
+		}
+		name := r.Host
+		if r.RunOnce {
+			name += " (run-once)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, r.Status, r.Duration.Round(time.Millisecond), msg)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,74 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatusOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Status
+	}{
+		{nil, Success},
+		{ErrHappened, Skipped},
+		{ErrNotAttempted, NotAttempted},
+		{&ConnectError{"Failed to connect to 10.0.0.1:22"}, Unreachable},
+		{fmt.Errorf("[one] %w", &ConnectError{"Failed to create session"}), Unreachable},
+		{errors.New("[one] Process exited with status 1"), Failed},
+	}
+	for _, test := range tests {
+		if got := StatusOf(test.err); got != test.want {
+			t.Error("Error:", test.err, "Want:", test.want, "Got:", got)
+		}
+	}
+}
+
+func TestResults(t *testing.T) {
+	rs := Results{
+		{Host: "one", Status: Success},
+		{Host: "two", Status: Skipped, Err: ErrHappened},
+		{Host: "three", Status: Unreachable, Err: errors.New("Failed to connect\nmore output")},
+	}
+	if !rs.Failed() {
+		t.Error("Expected results to have failed")
+	}
+	if rs[:2].Failed() {
+		t.Error("Expected results without failures")
+	}
+	if !(Results{{Host: "four", Status: NotAttempted, Err: ErrNotAttempted}}).Failed() {
+		t.Error("Expected results with hosts not attempted to have failed")
+	}
+	w := []string{"two", "three"}
+	g := rs.Names(Skipped, Unreachable)
+	if !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+	var b bytes.Buffer
+	if err := rs.Summary(&b); err != nil {
+		t.Error(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 {
+		t.Error("Want: 4 lines Got:", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], "HOST") {
+		t.Error("Want: HOST header Got:", lines[0])
+	}
+	if !strings.Contains(lines[3], "Failed to connect") || strings.Contains(lines[3], "more output") {
+		t.Error("Want: first line of error Got:", lines[3])
+	}
+	b.Reset()
+	Results{{Host: "one", RunOnce: true, Status: Success}}.Summary(&b)
+	if !strings.Contains(b.String(), "one (run-once)") {
+		t.Error("Want: one (run-once) Got:", b.String())
+	}
+}
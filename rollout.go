@@ -0,0 +1,301 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Plan describes how a command is rolled out to the hosts
+// Size hosts run at once, no new wave starts after more than Limit hosts
+// failed, and the Canary hosts run first.
+type Plan struct {
+	Size   int
+	Limit  int
+	Canary int
+}
+
+// Rollout runs a command on the hosts in waves
+// Run runs the command on a host and Ask asks the operator a question.
+// Batch, MaxFail, and Canary take precedence over the settings of the
+// deploys. With a LastRun only the hosts that failed in it are run.
+type Rollout struct {
+	Command string
+	Run     func(*Host) Result
+	Ask     func(string) bool
+	Out     io.Writer
+	Batch   string
+	MaxFail string
+	Canary  int
+	Yes     bool
+	List    bool
+	LastRun *LastRun
+}
+
+// Plan returns the plan for a number of hosts
+// The deploys are tried in order for the settings not given.
+func (r *Rollout) Plan(total int, deploys ...*Deploy) (Plan, error) {
+	b, m, c := r.Batch, r.MaxFail, r.Canary
+	for _, d := range deploys {
+		if b == "" {
+			b = d.Batch
+		}
+		if m == "" {
+			m = d.MaxFail
+		}
+		if c == 0 {
+			c = d.Canary
+		}
+	}
+	size, err := BatchSize(b, total)
+	if err != nil {
+		return Plan{}, err
+	}
+	limit, err := MaxFail(m, total)
+	if err != nil {
+		return Plan{}, err
+	}
+	if c < 0 {
+		return Plan{}, fmt.Errorf("invalid canary '%d'", c)
+	}
+	return Plan{Size: size, Limit: limit, Canary: c}, nil
+}
+
+// Deploy runs each stage of the named deploy in order on the selected hosts
+// A stage starts only after the previous stage succeeded on all its hosts.
+func (r *Rollout) Deploy(hf Hapfile, name, selection string) (Results, error) {
+	stages, err := hf.DeployStages(name)
+	if err != nil {
+		return nil, err
+	}
+	results := Results{}
+	for i, stage := range stages {
+		hosts, err := hf.DeployStageHosts(name, stage, selection)
+		if err != nil {
+			return results, err
+		}
+		once, err := hf.RunOnce(stage, hosts)
+		if err != nil {
+			return results, err
+		}
+		key := ""
+		if len(stages) > 1 {
+			key = stage
+		}
+		if r.LastRun != nil && r.LastRun.Ran(key) && !r.LastRun.RunOnceFailed(key) {
+			once = nil
+		}
+		hosts = r.LastRun.Retry(hosts, key)
+		if len(hosts) == 0 && once == nil {
+			fmt.Fprintf(r.Out, "[%s] No host found\n", stage)
+			continue
+		}
+		if r.List {
+			if len(stages) > 1 {
+				fmt.Fprintf(r.Out, "[%s]\n", stage)
+			}
+			ListHosts(r.Out, hosts)
+			continue
+		}
+		after := hf.Deploys[stage].RunOnceAfter
+		stageResults := Results{}
+		if once != nil && !after {
+			stageResults = r.runOnce(once)
+			if stageResults.Failed() && len(hosts) > 0 {
+				fmt.Fprintf(r.Out, "Run-once failed. Not attempted: %s\n", strings.Join(sortedNames(hosts), ", "))
+				stageResults = append(stageResults, notAttempted(sortedNames(hosts))...)
+			}
+		}
+		if !stageResults.Failed() && len(hosts) > 0 {
+			p, err := r.Plan(len(hosts), hf.Deploys[stage], hf.Deploys[name])
+			if err != nil {
+				return results, err
+			}
+			stageResults = append(stageResults, r.Hosts(hosts, p)...)
+		}
+		if once != nil && after {
+			if stageResults.Failed() {
+				stageResults = append(stageResults, notAttemptedOnce(once))
+			} else {
+				stageResults = append(stageResults, r.runOnce(once)...)
+			}
+		}
+		for _, result := range stageResults {
+			result.Stage = key
+			results = append(results, result)
+		}
+		if stageResults.Failed() {
+			if rest := stages[i+1:]; len(rest) > 0 {
+				fmt.Fprintf(r.Out, "Stage %s failed. Not run: %s\n", stage, strings.Join(rest, ", "))
+			}
+			break
+		}
+	}
+	return results, nil
+}
+
+// Hosts runs the command on the hosts following the plan
+// Hosts marked confirm are only run after the operator agrees.
+// The canary hosts run first and the rest wait for the operator.
+// No new wave is started once more than limit hosts have failed.
+// Hosts that were not run are returned as not attempted.
+func (r *Rollout) Hosts(hosts map[string]*Host, p Plan) Results {
+	keys := sortedNames(hosts)
+	confirm := []string{}
+	for _, key := range keys {
+		if hosts[key].Confirm {
+			confirm = append(confirm, key)
+		}
+	}
+	if len(confirm) > 0 && !r.Ask(fmt.Sprintf("Run `%s` on %s?", r.Command, strings.Join(confirm, ", "))) {
+		return notAttempted(keys)
+	}
+	results := Results{}
+	if p.Canary > 0 && p.Canary < len(keys) {
+		results = r.runWave(hosts, keys[:p.Canary])
+		keys = keys[p.Canary:]
+		fmt.Fprintln(r.Out)
+		results.Summary(r.Out)
+		if results.Failed() {
+			fmt.Fprintf(r.Out, "Canary failed. Not attempted: %s\n", strings.Join(keys, ", "))
+			return append(results, notAttempted(keys)...)
+		}
+		if !r.Yes && !r.Ask(fmt.Sprintf("Continue with the remaining %d hosts?", len(keys))) {
+			return append(results, notAttempted(keys)...)
+		}
+	}
+	skipped := []string{}
+	for _, wave := range Batches(keys, p.Size) {
+		if len(results.Names(Failed, Unreachable)) > p.Limit {
+			skipped = append(skipped, wave...)
+			continue
+		}
+		results = append(results, r.runWave(hosts, wave)...)
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(r.Out, "Stopped after %d failed hosts. Not attempted: %s\n",
+			len(results.Names(Failed, Unreachable)), strings.Join(skipped, ", "))
+	}
+	return append(results, notAttempted(skipped)...)
+}
+
+// runOnce runs the command on the run-once host
+func (r *Rollout) runOnce(once *Host) Results {
+	results := r.Hosts(map[string]*Host{once.Name: once}, Plan{Size: 1})
+	for i := range results {
+		results[i].RunOnce = true
+	}
+	return results
+}
+
+// runWave runs the command on the named hosts at once
+func (r *Rollout) runWave(hosts map[string]*Host, keys []string) Results {
+	results := make(Results, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, h *Host) {
+			defer wg.Done()
+			results[i] = r.Run(h)
+		}(i, hosts[key])
+	}
+	wg.Wait()
+	return results
+}
+
+// notAttempted returns results for hosts that were never run
+func notAttempted(keys []string) Results {
+	results := Results{}
+	for _, key := range keys {
+		results = append(results, Result{Host: key, Status: NotAttempted, Err: ErrNotAttempted})
+	}
+	return results
+}
+
+// notAttemptedOnce returns the result for run-once cmds that were never run
+func notAttemptedOnce(once *Host) Result {
+	r := notAttempted([]string{once.Name})[0]
+	r.RunOnce = true
+	return r
+}
+
+// ListHosts prints the hosts with their addr and tags
+func ListHosts(w io.Writer, hosts map[string]*Host) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, key := range sortedNames(hosts) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, hosts[key].Addr, strings.Join(hosts[key].Tag, ","))
+	}
+	tw.Flush()
+}
+
+// BatchSize converts a batch setting into the number of hosts per wave
+// The batch may be a count like "2" or a percentage like "25%".
+// An empty batch puts all hosts in one wave.
+func BatchSize(batch string, total int) (int, error) {
+	if batch == "" {
+		return total, nil
+	}
+	n, percent, err := parseCount(batch)
+	if err != nil {
+		return 0, fmt.Errorf("invalid batch '%s': %s", batch, err)
+	}
+	if percent {
+		n = (total*n + 99) / 100
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n, nil
+}
+
+// MaxFail converts a max-fail setting into the number of failed hosts allowed
+// The max-fail may be a count like "1" or a percentage like "10%".
+// An empty max-fail allows no failures.
+func MaxFail(maxFail string, total int) (int, error) {
+	if maxFail == "" {
+		return 0, nil
+	}
+	n, percent, err := parseCount(maxFail)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max-fail '%s': %s", maxFail, err)
+	}
+	if percent {
+		n = total * n / 100
+	}
+	return n, nil
+}
+
+// Batches splits the names into waves of at most size names
+func Batches(names []string, size int) [][]string {
+	if size < 1 {
+		size = len(names)
+	}
+	waves := [][]string{}
+	for len(names) > 0 {
+		if size > len(names) {
+			size = len(names)
+		}
+		waves = append(waves, names[:size])
+		names = names[size:]
+	}
+	return waves
+}
+
+func parseCount(value string) (int, bool, error) {
+	percent := strings.HasSuffix(value, "%")
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(value, "%")))
+	if err != nil {
+		return 0, percent, fmt.Errorf("expects a number or percentage")
+	}
+	if n < 0 || (percent && n > 100) {
+		return 0, percent, fmt.Errorf("out of range")
+	}
+	return n, percent, nil
+}
@@ -0,0 +1,252 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchSize(t *testing.T) {
+	tests := []struct {
+		batch string
+		total int
+		want  int
+	}{
+		{"", 10, 10},
+		{"3", 10, 3},
+		{"25%", 10, 3},
+		{"50%", 4, 2},
+		{"1%", 10, 1},
+		{"0", 10, 1},
+	}
+	for _, test := range tests {
+		got, err := BatchSize(test.batch, test.total)
+		if err != nil {
+			t.Error(err)
+		}
+		if got != test.want {
+			t.Error("Batch:", test.batch, "Want:", test.want, "Got:", got)
+		}
+	}
+	if _, err := BatchSize("ten", 10); err == nil {
+		t.Error("Expected error for invalid batch")
+	}
+	if _, err := BatchSize("150%", 10); err == nil {
+		t.Error("Expected error for batch over 100%")
+	}
+}
+
+func TestMaxFail(t *testing.T) {
+	tests := []struct {
+		maxFail string
+		total   int
+		want    int
+	}{
+		{"", 10, 0},
+		{"2", 10, 2},
+		{"10%", 10, 1},
+		{"25%", 10, 2},
+	}
+	for _, test := range tests {
+		got, err := MaxFail(test.maxFail, test.total)
+		if err != nil {
+			t.Error(err)
+		}
+		if got != test.want {
+			t.Error("MaxFail:", test.maxFail, "Want:", test.want, "Got:", got)
+		}
+	}
+	if _, err := MaxFail("-1", 10); err == nil {
+		t.Error("Expected error for negative max-fail")
+	}
+}
+
+func TestBatches(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e"}
+	w := [][]string{{"a", "b"}, {"c", "d"}, {"e"}}
+	g := Batches(names, 2)
+	if !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+	w = [][]string{{"a", "b", "c", "d", "e"}}
+	g = Batches(names, 0)
+	if !reflect.DeepEqual(w, g) {
+		t.Error("Want:", w, "Got:", g)
+	}
+}
+
+func TestRolloutDeploy(t *testing.T) {
+	cfgStr := `
+[host "one"]
+addr = "10.0.0.1:22"
+
+[host "two"]
+addr = "10.0.0.2:22"
+
+[host "three"]
+addr = "10.0.0.3:22"
+
+[deploy "before"]
+host = one
+host = two
+cmd = ./update.sh
+run-once = ./migrate.sh
+batch = 1
+
+[deploy "after"]
+host = one
+host = two
+cmd = ./update.sh
+run-once = ./migrate.sh
+run-once-after = true
+batch = 1
+
+[deploy "app"]
+host = one
+host = two
+cmd = ./app.sh
+batch = 1
+
+[deploy "web"]
+host = three
+cmd = ./web.sh
+
+[deploy "staged"]
+stage = app
+stage = web
+
+[deploy "canary"]
+host = one
+host = two
+host = three
+cmd = ./update.sh
+canary = 1
+
+[deploy "limited"]
+host = one
+host = two
+host = three
+cmd = ./update.sh
+batch = 1
+max-fail = 1
+
+[deploy "confirmed"]
+host = one
+cmd = ./update.sh
+confirm = true`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.Remove("TestHapfile")
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		deploy  string
+		fail    []string
+		answer  bool
+		ran     []string
+		results []string
+	}{
+		{"before", nil, false,
+			[]string{"one ./migrate.sh", "one ./update.sh", "two ./update.sh"},
+			[]string{" one once success", " one success", " two success"}},
+		{"after", nil, false,
+			[]string{"one ./update.sh", "two ./update.sh", "one ./migrate.sh"},
+			[]string{" one success", " two success", " one once success"}},
+		{"before", []string{"one ./migrate.sh"}, false,
+			[]string{"one ./migrate.sh"},
+			[]string{" one once failed", " one not-attempted", " two not-attempted"}},
+		{"after", []string{"one ./update.sh"}, false,
+			[]string{"one ./update.sh"},
+			[]string{" one failed", " two not-attempted", " one once not-attempted"}},
+		{"staged", nil, false,
+			[]string{"one ./app.sh", "two ./app.sh", "three ./web.sh"},
+			[]string{"app one success", "app two success", "web three success"}},
+		{"staged", []string{"one ./app.sh"}, false,
+			[]string{"one ./app.sh"},
+			[]string{"app one failed", "app two not-attempted"}},
+		{"canary", []string{"one ./update.sh"}, true,
+			[]string{"one ./update.sh"},
+			[]string{" one failed", " three not-attempted", " two not-attempted"}},
+		{"canary", nil, false,
+			[]string{"one ./update.sh"},
+			[]string{" one success", " three not-attempted", " two not-attempted"}},
+		{"limited", []string{"one ./update.sh", "three ./update.sh"}, false,
+			[]string{"one ./update.sh", "three ./update.sh"},
+			[]string{" one failed", " three failed", " two not-attempted"}},
+		{"confirmed", nil, false,
+			[]string{},
+			[]string{" one not-attempted"}},
+		{"confirmed", nil, true,
+			[]string{"one ./update.sh"},
+			[]string{" one success"}},
+	}
+	for _, test := range tests {
+		var mu sync.Mutex
+		ran := []string{}
+		r := &Rollout{
+			Command: "deploy",
+			Run: func(h *Host) Result {
+				cmd := h.Name + " " + strings.Join(h.Cmds(), " ")
+				mu.Lock()
+				ran = append(ran, cmd)
+				mu.Unlock()
+				var err error
+				if contains(test.fail, cmd) {
+					err = errors.New("failed")
+				}
+				return NewResult(h.Name, time.Now(), err)
+			},
+			Ask: func(string) bool {
+				return test.answer
+			},
+			Out: ioutil.Discard,
+		}
+		results, err := r.Deploy(hf, test.deploy, "*")
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(test.ran, ran) {
+			t.Error("Deploy:", test.deploy, "Want:", test.ran, "Got:", ran)
+		}
+		got := []string{}
+		for _, result := range results {
+			once := ""
+			if result.RunOnce {
+				once = " once"
+			}
+			got = append(got, result.Stage+" "+result.Host+once+" "+string(result.Status))
+		}
+		if !reflect.DeepEqual(test.results, got) {
+			t.Error("Deploy:", test.deploy, "Want:", test.results, "Got:", got)
+		}
+	}
+}
+
+func TestRolloutPlan(t *testing.T) {
+	r := &Rollout{Batch: "2"}
+	p, err := r.Plan(10, &Deploy{Batch: "5", MaxFail: "20%", Canary: 1})
+	if err != nil {
+		t.Error(err)
+	}
+	w := Plan{Size: 2, Limit: 2, Canary: 1}
+	if p != w {
+		t.Error("Want:", w, "Got:", p)
+	}
+	r = &Rollout{Canary: -1}
+	if _, err := r.Plan(10); err == nil {
+		t.Error("Expected error for a negative canary")
+	}
+}
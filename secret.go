@@ -0,0 +1,254 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// SecretHeader is the first line of an encrypted env file
+const SecretHeader = "hap-secret v1"
+
+// Sizes of the salt and the derived key of an encrypted env file
+const (
+	saltSize = 16
+	keySize  = 32
+)
+
+// Encrypt seals the plain text with a key derived from the passphrase
+// The result is the header followed by the base64 of salt, nonce and cipher text.
+func Encrypt(plain []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	gcm, err := secretCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(append(salt, nonce...), nonce, plain, []byte(SecretHeader))
+	return []byte(SecretHeader + "\n" + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// Decrypt opens data sealed by Encrypt with the passphrase
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	lines := strings.SplitN(string(data), "\n", 2)
+	if len(lines) != 2 || lines[0] != SecretHeader {
+		return nil, fmt.Errorf("not encrypted, expects '%s'", SecretHeader)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sealed) < saltSize {
+		return nil, fmt.Errorf("corrupted secret")
+	}
+	gcm, err := secretCipher(passphrase, sealed[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[saltSize:]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("corrupted secret")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(SecretHeader))
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted secret")
+	}
+	return plain, nil
+}
+
+// secretCipher returns AES-256-GCM with the key derived by scrypt
+func secretCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsSecret reports whether the file starts with the SecretHeader
+func IsSecret(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadBytes('\n')
+	return bytes.Equal(bytes.TrimRight(line, "\r\n"), []byte(SecretHeader))
+}
+
+// Secrets decrypts the encrypted env files of the hosts
+// The passphrase is asked for once, and only if there is an encrypted file.
+func Secrets(hosts []*Host, passphrase func() (string, error)) (map[string]string, error) {
+	secrets := map[string]string{}
+	var pass *string
+	for _, h := range hosts {
+		for _, file := range h.Env {
+			if _, ok := secrets[file]; ok || !IsSecret(file) {
+				continue
+			}
+			if pass == nil {
+				p, err := passphrase()
+				if err != nil {
+					return nil, err
+				}
+				pass = &p
+			}
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			plain, err := Decrypt(data, *pass)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+			secrets[file] = string(plain)
+		}
+	}
+	return secrets, nil
+}
+
+// secretVar returns the shell variable that holds the env file at index i
+func secretVar(i int) string {
+	return fmt.Sprintf("HAP_SECRET_%d", i)
+}
+
+// secretPattern matches the !commands kept in the values of a host
+var secretPattern = regexp.MustCompile("\x00(![^\x00]*)\x00")
+
+// secret returns the value with a !command kept until ResolveSecrets
+// A value starting with !! is returned with a single !.
+func secret(value string) string {
+	if strings.HasPrefix(value, "!!") {
+		return value[1:]
+	}
+	if strings.HasPrefix(value, "!") {
+		return "\x00" + value + "\x00"
+	}
+	return value
+}
+
+// unresolved returns the value with the !commands as they were written
+func unresolved(value string) string {
+	return secretPattern.ReplaceAllString(value, "$1")
+}
+
+// ResolveSecrets replaces the !commands of the host with their output
+// The commands are only run for the hosts that are run, never on load.
+func (h *Host) ResolveSecrets() error {
+	var err error
+	resolve := func(value string) string {
+		return secretPattern.ReplaceAllStringFunc(value, func(m string) string {
+			if err != nil {
+				return m
+			}
+			var v string
+			v, err = Resolve(m[1 : len(m)-1])
+			return v
+		})
+	}
+	resolveAll := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		results := make([]string, len(values))
+		for i, value := range values {
+			results[i] = resolve(value)
+		}
+		return results
+	}
+	for _, field := range h.scalars() {
+		*field = resolve(*field)
+	}
+	h.Env = resolveAll(h.Env)
+	h.cmds, h.onFailure, h.always = resolveAll(h.cmds), resolveAll(h.onFailure), resolveAll(h.always)
+	vars := map[string]string{}
+	for name, value := range h.vars {
+		vars[name] = resolve(value)
+	}
+	h.vars = vars
+	files := make([]hostFile, len(h.files))
+	for i, f := range h.files {
+		f.Src, f.Dest = resolve(f.Src), resolve(f.Dest)
+		files[i] = f
+	}
+	h.files = files
+	if err != nil {
+		return fmt.Errorf("[%s] %s", h.Name, err)
+	}
+	return nil
+}
+
+// resolved holds the output of the secret commands by command
+var resolved = struct {
+	sync.Mutex
+	values map[string]string
+}{values: map[string]string{}}
+
+// Resolve returns the output of the local command of a value like !pass show prod/ssh
+// Each command runs once per run and its output is masked by Mask.
+// Values without a leading ! are returned as is, and !! escapes the !.
+func Resolve(value string) (string, error) {
+	if !strings.HasPrefix(value, "!") {
+		return value, nil
+	}
+	if strings.HasPrefix(value, "!!") {
+		return value[1:], nil
+	}
+	command := strings.TrimSpace(value[1:])
+	resolved.Lock()
+	defer resolved.Unlock()
+	if v, ok := resolved.values[command]; ok {
+		return v, nil
+	}
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("`%s` failed: %s", command, err)
+	}
+	v := strings.TrimRight(string(out), "\r\n")
+	resolved.values[command] = v
+	return v, nil
+}
+
+// Mask replaces the values returned by Resolve with ****
+func Mask(s string) string {
+	resolved.Lock()
+	values := []string{}
+	for _, v := range resolved.values {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	resolved.Unlock()
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		s = strings.Replace(s, v, "****", -1)
+	}
+	return s
+}
@@ -0,0 +1,171 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	plain := []byte("TOKEN='it''s secret'\n")
+	data, err := Encrypt(plain, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret'") || !strings.HasPrefix(string(data), SecretHeader+"\n") {
+		t.Error("Want: encrypted with header Got:", string(data))
+	}
+	g, err := Decrypt(data, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plain, g) {
+		t.Error("Want:", string(plain), "Got:", string(g))
+	}
+	if _, err := Decrypt(data, "wrong"); err == nil {
+		t.Error("Expected an error with the wrong passphrase")
+	}
+	if _, err := Decrypt(plain, "passphrase"); err == nil {
+		t.Error("Expected an error for a file that is not encrypted")
+	}
+}
+
+func TestSecrets(t *testing.T) {
+	data, _ := Encrypt([]byte("export TOKEN=abc\n"), "passphrase")
+	ioutil.WriteFile("TestSecret.env", data, 0600)
+	defer os.Remove("TestSecret.env")
+	ioutil.WriteFile("TestPlain.env", []byte("export TOKEN=plain NAME=plain\n"), 0644)
+	defer os.Remove("TestPlain.env")
+
+	if !IsSecret("TestSecret.env") || IsSecret("TestPlain.env") {
+		t.Error("Want: only TestSecret.env encrypted")
+	}
+	asked := 0
+	passphrase := func() (string, error) {
+		asked++
+		return "passphrase", nil
+	}
+	plain := &Host{Env: []string{"TestPlain.env"}}
+	secrets, err := Secrets([]*Host{plain}, passphrase)
+	if err != nil || len(secrets) != 0 || asked != 0 {
+		t.Error("Want: no passphrase asked without encrypted files Got:", asked, secrets, err)
+	}
+	h := &Host{Name: "one", Env: []string{"TestPlain.env", "TestSecret.env"}}
+	secrets, err = Secrets([]*Host{h, h}, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := map[string]string{"TestSecret.env": "export TOKEN=abc\n"}
+	if !reflect.DeepEqual(w, secrets) || asked != 1 {
+		t.Error("Want:", w, 1, "Got:", secrets, asked)
+	}
+
+	wc := []string{". ./TestPlain.env", "eval \"$HAP_SECRET_1\""}
+	gc := h.AddEnv([]string{})
+	if !reflect.DeepEqual(wc, gc) {
+		t.Error("Want:", wc, "Got:", gc)
+	}
+	r := &Remote{Host: h, Secrets: secrets}
+	if !r.hasSecrets() {
+		t.Error("Want: the host to have secrets")
+	}
+	env, err := r.secretEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := env + strings.Join(append(gc, "echo $TOKEN $NAME"), " &&\n")
+	out, err := exec.Command("sh", "-c", script).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws, gs := "abc plain\n", string(out); ws != gs {
+		t.Error("Want:", ws, "Got:", gs)
+	}
+	shell, err := exec.LookPath("bash")
+	if err != nil {
+		shell = "sh"
+	}
+	script, err = r.script(strings.Join(append(gc, "cat", "echo $TOKEN"), " &&\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(shell, "-s")
+	cmd.Stdin = strings.NewReader(script)
+	if out, err = cmd.Output(); err != nil {
+		t.Fatal(err)
+	}
+	if ws, gs := "abc\n", string(out); ws != gs {
+		t.Error("Want:", ws, "Got:", gs)
+	}
+	r.Secrets = nil
+	if _, err := r.secretEnv(); err == nil {
+		t.Error("Expected an error for an env file that was not decrypted")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	ioutil.WriteFile("TestHapfile", []byte(`
+[vars]
+var = token=!echo s3cr3t-token
+var = bang=!!not a command
+
+[host "one"]
+addr = "10.0.0.1:22"
+password = !printf s3cr3t-pass
+cmd = ./release.sh ${token} ${bang}
+
+[host "two"]
+addr = "10.0.0.2:22"
+password = !touch TestResolved
+var = lazy=!touch TestResolved
+cmd = ./deploy.sh ${lazy}
+`), 0644)
+	defer os.Remove("TestHapfile")
+	defer os.Remove("TestResolved")
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := hf.Show("", "two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, g := "./deploy.sh !touch TestResolved", configs[0].Cmd[0].Value; w != g {
+		t.Error("Want:", w, "Got:", g)
+	}
+	if _, err := os.Stat("TestResolved"); !os.IsNotExist(err) {
+		t.Error("Want: no command run on load Got:", err)
+	}
+	h := hf.Host("one")
+	if w, g := "!printf s3cr3t-pass", unresolved(h.Password); w != g {
+		t.Error("Want:", w, "Got:", g)
+	}
+	if err := h.ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if w, g := "s3cr3t-pass", h.Password; w != g {
+		t.Error("Want:", w, "Got:", g)
+	}
+	wc := []string{"./release.sh s3cr3t-token !not a command"}
+	if !reflect.DeepEqual(wc, h.Cmds()) {
+		t.Error("Want:", wc, "Got:", h.Cmds())
+	}
+	if w, g := "token=**** pass=**** !not a command", Mask("token=s3cr3t-token pass=s3cr3t-pass !not a command"); w != g {
+		t.Error("Want:", w, "Got:", g)
+	}
+	var b strings.Builder
+	NewRemoteWriter("one", &b).Write([]byte("using s3cr3t-token\n"))
+	if w, g := "[one] using ****\n", b.String(); w != g {
+		t.Error("Want:", w, "Got:", g)
+	}
+	if _, err := Resolve("!exit 3"); err == nil {
+		t.Error("Expected an error for a failed command")
+	}
+}
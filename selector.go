@@ -0,0 +1,147 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Matches numeric ranges like [01:12] in a pattern
+var rangePattern = regexp.MustCompile(`\[(\d+):(\d+)\]`)
+
+// Select returns the sorted names of the hosts matching the selection
+// A selection is a comma separated list of patterns that are combined.
+// A pattern is a glob like `app-*`, a range like `app-[01:12]`,
+// or a tag like `@web`. Patterns joined with `&` must all match,
+// and a pattern starting with `!` removes the hosts it matches.
+func Select(hosts map[string]*Host, selection string) ([]string, error) {
+	included := map[string]bool{}
+	excluded := map[string]bool{}
+	onlyExcludes := true
+	for _, item := range strings.Split(selection, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("invalid host selection '%s'", selection)
+		}
+		target := included
+		if strings.HasPrefix(item, "!") {
+			target = excluded
+			item = item[1:]
+		} else {
+			onlyExcludes = false
+		}
+		for name, host := range hosts {
+			ok, err := matchAll(item, name, host)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				target[name] = true
+			}
+		}
+	}
+	names := []string{}
+	for name := range hosts {
+		if (included[name] || onlyExcludes) && !excluded[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// matchAll checks the host against every pattern joined by &
+func matchAll(item, name string, host *Host) (bool, error) {
+	for _, pattern := range strings.Split(item, "&") {
+		pattern = strings.TrimSpace(pattern)
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
+		}
+		if pattern == "" {
+			return false, fmt.Errorf("invalid host selection '%s'", item)
+		}
+		ok, err := match(pattern, name, host)
+		if err != nil {
+			return false, err
+		}
+		if ok == negate {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// match checks the host against a single tag or name pattern
+func match(pattern, name string, host *Host) (bool, error) {
+	if strings.HasPrefix(pattern, "@") {
+		if host == nil {
+			return false, nil
+		}
+		for _, tag := range host.Tag {
+			ok, err := filepath.Match(pattern[1:], tag)
+			if err != nil {
+				return false, fmt.Errorf("invalid tag pattern '%s': %s", pattern, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if err := checkRanges(pattern); err != nil {
+		return false, err
+	}
+	return matchRanges(pattern, name), nil
+}
+
+// checkRanges reports invalid ranges and globs in the pattern
+func checkRanges(pattern string) error {
+	for _, m := range rangePattern.FindAllStringSubmatch(pattern, -1) {
+		start, err := strconv.Atoi(m[1])
+		if err != nil {
+			return fmt.Errorf("invalid host range '%s'", m[0])
+		}
+		end, err := strconv.Atoi(m[2])
+		if err != nil || start > end {
+			return fmt.Errorf("invalid host range '%s'", m[0])
+		}
+	}
+	if _, err := filepath.Match(rangePattern.ReplaceAllString(pattern, "0"), ""); err != nil {
+		return fmt.Errorf("invalid host pattern '%s': %s", pattern, err)
+	}
+	return nil
+}
+
+// matchRanges matches the name against a pattern with ranges like `app-[01:03]`
+// A number in the name matches a range when it is within the range and
+// has the width of its start, so a range is never expanded.
+func matchRanges(pattern, name string) bool {
+	loc := rangePattern.FindStringSubmatchIndex(pattern)
+	if loc == nil {
+		ok, _ := filepath.Match(pattern, name)
+		return ok
+	}
+	from := pattern[loc[2]:loc[3]]
+	start, _ := strconv.Atoi(from)
+	end, _ := strconv.Atoi(pattern[loc[4]:loc[5]])
+	for i := range name {
+		for j := i + 1; j <= len(name) && name[j-1] >= '0' && name[j-1] <= '9'; j++ {
+			n, err := strconv.Atoi(name[i:j])
+			if err != nil || n < start || n > end || name[i:j] != fmt.Sprintf("%0*d", len(from), n) {
+				continue
+			}
+			if ok, _ := filepath.Match(pattern[:loc[0]], name[:i]); ok && matchRanges(pattern[loc[1]:], name[j:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	hosts := map[string]*Host{
+		"app-01": &Host{Tag: []string{"web", "eu"}},
+		"app-02": &Host{Tag: []string{"web", "us"}},
+		"app-10": &Host{Tag: []string{"web", "eu"}},
+		"db-01":  &Host{Tag: []string{"db", "eu"}},
+		"lb":     &Host{},
+	}
+	tests := []struct {
+		selection string
+		want      []string
+	}{
+		{"*", []string{"app-01", "app-02", "app-10", "db-01", "lb"}},
+		{"lb", []string{"lb"}},
+		{"app-*,lb", []string{"app-01", "app-02", "app-10", "lb"}},
+		{"@db", []string{"db-01"}},
+		{"@web&@eu", []string{"app-01", "app-10"}},
+		{"@eu&!@db", []string{"app-01", "app-10"}},
+		{"app-*,!app-02", []string{"app-01", "app-10"}},
+		{"!@web", []string{"db-01", "lb"}},
+		{"app-[01:02]", []string{"app-01", "app-02"}},
+		{"app-[1:10]", []string{"app-10"}},
+		{"app-[09:10],db-[1:1]", []string{"app-10"}},
+		{"app-[0:99999999]", []string{"app-10"}},
+		{"*-[00:99999999999]&!db-*", []string{"app-01", "app-02", "app-10"}},
+		{"missing", []string{}},
+	}
+	for _, test := range tests {
+		got, err := Select(hosts, test.selection)
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(test.want, got) {
+			t.Error("Selection:", test.selection, "Want:", test.want, "Got:", got)
+		}
+	}
+	for _, selection := range []string{"", "app-*,", "app-[10:01]", "@web&", "app-[0", "app-[1:99999999999999999999]"} {
+		if _, err := Select(hosts, selection); err == nil {
+			t.Error("Expected error for selection:", selection)
+		}
+	}
+}
+
+func TestNewHapfileWithTags(t *testing.T) {
+	cfgStr := `
+[host "one"]
+addr = "10.0.0.1:22"
+tag = web
+
+[host "two"]
+addr = "10.0.0.2:22"
+tag = web
+tag = eu
+
+[deploy "together"]
+host = one
+host = two
+cmd = echo`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	hosts := hf.GetHosts("@web&@eu")
+	if len(hosts) != 1 || hosts["two"] == nil {
+		t.Error("Want: two Got:", hosts)
+	}
+	hosts, err = hf.GetDeployHosts("together", "@web,!two")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(hosts) != 1 || hosts["one"] == nil {
+		t.Error("Want: one Got:", hosts)
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
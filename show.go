@@ -0,0 +1,158 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+)
+
+// Value is a resolved setting and the file and section that set it
+type Value struct {
+	Value  string `json:"value"`
+	Source string `json:"source,omitempty"`
+}
+
+// HostConfig is the resolved configuration of a host
+type HostConfig struct {
+	Name     string  `json:"name"`
+	Deploy   string  `json:"deploy,omitempty"`
+	Addr     Value   `json:"addr"`
+	Username Value   `json:"username"`
+	Dir      Value   `json:"dir"`
+	Identity Value   `json:"identity"`
+	Env      []Value `json:"env"`
+	Cmd      []Value `json:"cmd"`
+}
+
+// Show returns the resolved configuration of the selected hosts
+// With a deploy, the hosts of the deploy are shown with its settings.
+func (hf Hapfile) Show(deploy, selection string) ([]HostConfig, error) {
+	hosts := map[string]*Host{}
+	if deploy != "" {
+		var err error
+		if hosts, err = hf.GetDeployHosts(deploy, selection); err != nil {
+			return nil, err
+		}
+	} else {
+		names, err := Select(hf.Hosts, selection)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			hosts[name] = hf.Host(name)
+		}
+	}
+	configs := []HostConfig{}
+	for _, name := range sortedNames(hosts) {
+		configs = append(configs, hf.hostConfig(deploy, name, hosts[name]))
+	}
+	return configs, nil
+}
+
+// hostConfig finds where each setting of the resolved host came from
+// The sections are merged like the settings, with each value replaced
+// by the file and section that set it.
+func (hf Hapfile) hostConfig(deploy, name string, h *Host) HostConfig {
+	base := &Host{Env: []string{}}
+	for _, file := range hf.Env.File {
+		base.Env = append(base.Env, hf.source("env "+file, "env"))
+	}
+	d := Host(hf.Default)
+	defaults := label(&d, func(key string) string {
+		return hf.source("default "+key, "default")
+	})
+	general := MergeHost(base, defaults)
+	l := MergeHost(general, hf.labeled("host", name))
+	if deploy != "" {
+		section := "deploy " + deploy
+		l = MergeHost(deployParent(l, general, hf.Deploys[deploy]), label(hf.Deploys[deploy].host(), func(string) string {
+			return hf.source(section, section)
+		}))
+	}
+	cmd := []string{}
+	order, _ := BuildOrder(hf.Builds, h.Build)
+	for _, build := range order {
+		for range hf.Builds[build].Cmd {
+			cmd = append(cmd, hf.source("build "+build, "build "+build))
+		}
+	}
+	return HostConfig{
+		Name:     name,
+		Deploy:   deploy,
+		Addr:     Value{unresolved(h.Addr), l.Addr},
+		Username: Value{unresolved(h.Username), l.Username},
+		Dir:      Value{unresolved(h.GetDir()), l.Dir},
+		Identity: Value{unresolved(h.Identity), l.Identity},
+		Env:      values(h.Env, l.Env),
+		Cmd:      values(h.Cmds(), append(cmd, l.Cmd...)),
+	}
+}
+
+// labeled returns the host or template merged onto the sections it
+// inherits from, with each value replaced by the section that set it
+func (hf Hapfile) labeled(kind, name string) *Host {
+	h := hf.raw[kind+" "+name]
+	if h == nil {
+		return &Host{}
+	}
+	section := kind + " " + name
+	if inv, ok := hf.inventoried[name]; ok && kind == "host" {
+		section = "inventory " + inv
+	}
+	l := label(h, func(string) string { return hf.source(section, section) })
+	if h.Inherit == "" {
+		return l
+	}
+	kind = "host"
+	if _, ok := hf.raw["template "+h.Inherit]; ok {
+		kind = "template"
+	}
+	return inheritHost(hf.labeled(kind, h.Inherit), l)
+}
+
+// label returns a copy of the host with each value replaced by the source of its key
+func label(h *Host, source func(key string) string) *Host {
+	l := *h
+	for key, value := range l.scalars() {
+		if *value != "" {
+			*value = source(key)
+		}
+	}
+	for key, list := range l.lists() {
+		*list = repeat(source(key), len(*list))
+	}
+	return &l
+}
+
+// source returns the file of a key with the section like Hapfile [host one]
+func (hf Hapfile) source(key, section string) string {
+	file, ok := hf.sources[key]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s [%s]", file, section)
+}
+
+// repeat returns a list with the value n times
+func repeat(value string, n int) []string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = value
+	}
+	return list
+}
+
+// values pairs each value with its source
+func values(list, sources []string) []Value {
+	results := []Value{}
+	for i, v := range list {
+		value := Value{Value: unresolved(v)}
+		if i < len(sources) {
+			value.Source = sources[i]
+		}
+		results = append(results, value)
+	}
+	return results
+}
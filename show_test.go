@@ -0,0 +1,102 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestShow(t *testing.T) {
+	cfgStr := `
+[default]
+username = root
+build = init
+
+[env]
+file = environment
+
+[template "web"]
+dir = /srv/web
+cmd = ./web.sh
+
+[host "one"]
+inherit = web
+addr = "10.0.0.1:22"
+env = one_environment
+
+[deploy "release"]
+host = one
+env = release_environment
+cmd = ./release.sh
+
+[include]
+path = TestAnotherHapfile`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	cfgStr = `
+[default]
+username = admin
+identity = ~/.ssh/id_rsa
+
+[build "init"]
+cmd = ./init.sh`
+	err = ioutil.WriteFile("TestAnotherHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := hf.Show("", "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := HostConfig{
+		Name:     "one",
+		Addr:     Value{"10.0.0.1:22", "TestHapfile [host one]"},
+		Username: Value{"root", "TestHapfile [default]"},
+		Dir:      Value{"/srv/web", "TestHapfile [template web]"},
+		Identity: Value{"~/.ssh/id_rsa", "TestAnotherHapfile [default]"},
+		Env: []Value{
+			{"environment", "TestHapfile [env]"},
+			{"one_environment", "TestHapfile [host one]"},
+		},
+		Cmd: []Value{
+			{"./init.sh", "TestAnotherHapfile [build init]"},
+			{"./web.sh", "TestHapfile [template web]"},
+		},
+	}
+	if len(configs) != 1 || !reflect.DeepEqual(w, configs[0]) {
+		t.Error("Want:", w, "Got:", configs)
+	}
+
+	configs, err = hf.Show("release", "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Deploy = "release"
+	w.Env = append(w.Env, Value{"release_environment", "TestHapfile [deploy release]"})
+	w.Cmd[1] = Value{"./release.sh", "TestHapfile [deploy release]"}
+	if len(configs) != 1 || !reflect.DeepEqual(w, configs[0]) {
+		t.Error("Want:", w, "Got:", configs)
+	}
+	if _, err := hf.Show("missing", "*"); err == nil {
+		t.Error("Expected error for unknown deploy")
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	err = os.Remove("TestAnotherHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,220 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tfInstance is a managed resource instance from terraform
+type tfInstance struct {
+	Type       string
+	Name       string
+	Address    string
+	Index      string
+	Attributes map[string]interface{}
+}
+
+// tfModule is a module in the output of terraform show -json
+type tfModule struct {
+	Resources []struct {
+		Address string                 `json:"address"`
+		Mode    string                 `json:"mode"`
+		Type    string                 `json:"type"`
+		Name    string                 `json:"name"`
+		Index   interface{}            `json:"index"`
+		Values  map[string]interface{} `json:"values"`
+	} `json:"resources"`
+	ChildModules []tfModule `json:"child_modules"`
+}
+
+// readTerraform returns the managed resource instances of a
+// terraform.tfstate or of the output of terraform show -json
+func readTerraform(file string) ([]tfInstance, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var state struct {
+		Version   int `json:"version"`
+		Resources []struct {
+			Module    string `json:"module"`
+			Mode      string `json:"mode"`
+			Type      string `json:"type"`
+			Name      string `json:"name"`
+			Instances []struct {
+				IndexKey   interface{}            `json:"index_key"`
+				Attributes map[string]interface{} `json:"attributes"`
+			} `json:"instances"`
+		} `json:"resources"`
+		Values *struct {
+			RootModule tfModule `json:"root_module"`
+		} `json:"values"`
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&state); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	instances := []tfInstance{}
+	if state.Values != nil {
+		var walk func(m tfModule)
+		walk = func(m tfModule) {
+			for _, r := range m.Resources {
+				if r.Mode == "managed" {
+					instances = append(instances, tfInstance{r.Type, r.Name, r.Address, tfIndex(r.Index), r.Values})
+				}
+			}
+			for _, child := range m.ChildModules {
+				walk(child)
+			}
+		}
+		walk(state.Values.RootModule)
+		return instances, nil
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("%s: expects a version 4 state or the output of terraform show -json", file)
+	}
+	for _, r := range state.Resources {
+		if r.Mode != "managed" {
+			continue
+		}
+		address := r.Type + "." + r.Name
+		if r.Module != "" {
+			address = r.Module + "." + address
+		}
+		for _, i := range r.Instances {
+			inst := tfInstance{r.Type, r.Name, address, tfIndex(i.IndexKey), i.Attributes}
+			if s, ok := i.IndexKey.(string); ok {
+				inst.Address += fmt.Sprintf("[%q]", s)
+			} else if i.IndexKey != nil {
+				inst.Address += "[" + inst.Index + "]"
+			}
+			instances = append(instances, inst)
+		}
+	}
+	return instances, nil
+}
+
+// tfIndex returns the count or for_each index as a string
+func tfIndex(index interface{}) string {
+	if index == nil {
+		return ""
+	}
+	return fmt.Sprint(index)
+}
+
+// lookup finds an attribute like tags.Name or resource.name
+// Attributes that are null, maps, or lists are not defined. The values
+// are escaped, so ${ in an attribute is kept when the template is rendered.
+func (inst tfInstance) lookup(name string) (string, bool) {
+	value, ok := inst.attribute(name)
+	return escapeVars(value), ok
+}
+
+// hostLookup finds an attribute for a setting interpolated again by the Hapfile
+// The values are escaped twice, so they stay escaped after the template.
+func (inst tfInstance) hostLookup(name string) (string, bool) {
+	value, ok := inst.lookup(name)
+	return escapeVars(value), ok
+}
+
+// attribute returns the value of an attribute like tags.Name or resource.name
+func (inst tfInstance) attribute(name string) (string, bool) {
+	switch name {
+	case "resource.type":
+		return inst.Type, true
+	case "resource.name":
+		return inst.Name, true
+	case "resource.address":
+		return inst.Address, true
+	case "resource.index":
+		return inst.Index, true
+	}
+	var v interface{} = inst.Attributes
+	for _, part := range strings.Split(name, ".") {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = value[part]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(value) {
+				return "", false
+			}
+			v = value[i]
+		default:
+			return "", false
+		}
+	}
+	switch v.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
+
+// terraformHosts returns a host for each resource instance of the types
+// The templates of the inventory are rendered with the attributes and a
+// relative terraform file is read from dir.
+func (inv *Inventory) terraformHosts(dir string) ([]InventoryHost, error) {
+	if inv.Name == "" || inv.Addr == "" {
+		return nil, fmt.Errorf("expects name and addr templates")
+	}
+	file := inv.Terraform
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	instances, err := readTerraform(file)
+	if err != nil {
+		return nil, err
+	}
+	hosts := []InventoryHost{}
+	for _, inst := range instances {
+		if len(inv.Type) > 0 && !contains(inv.Type, inst.Type) {
+			continue
+		}
+		h := InventoryHost{Vars: map[string]string{}}
+		for _, f := range []struct {
+			dst      *string
+			template string
+			lookup   func(string) (string, bool)
+		}{
+			{&h.Name, inv.Name, inst.lookup},
+			{&h.Addr, inv.Addr, inst.hostLookup},
+			{&h.Username, inv.Username, inst.hostLookup},
+			{&h.Identity, inv.Identity, inst.hostLookup},
+			{&h.Dir, inv.Dir, inst.hostLookup},
+			{&h.Inherit, inv.Inherit, inst.lookup},
+		} {
+			if *f.dst, err = Interpolate(f.template, f.lookup); err != nil {
+				return nil, fmt.Errorf("%s: %s", inst.Address, err)
+			}
+		}
+		if h.Tags, err = interpolateAll(inv.Tag, inst.lookup); err != nil {
+			return nil, fmt.Errorf("%s: %s", inst.Address, err)
+		}
+		if h.Builds, err = interpolateAll(inv.Build, inst.lookup); err != nil {
+			return nil, fmt.Errorf("%s: %s", inst.Address, err)
+		}
+		entries, err := interpolateAll(inv.Var, inst.hostLookup)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", inst.Address, err)
+		}
+		if h.Vars, err = ParseVars(entries); err != nil {
+			return nil, fmt.Errorf("%s: %s", inst.Address, err)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,119 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNewHapfileWithTerraform(t *testing.T) {
+	state := `{
+  "version": 4,
+  "resources": [
+    {
+      "mode": "managed", "type": "aws_instance", "name": "web",
+      "instances": [
+        {"index_key": 0, "attributes": {"public_ip": "10.0.0.1", "tags": {"Name": "web-01", "Role": "web", "Note": ""}}},
+        {"index_key": 1, "attributes": {"public_ip": "10.0.0.2", "tags": {"Name": "web-02", "Role": "web", "Note": "${nope} ${env.HOME}"}}}
+      ]
+    },
+    {
+      "mode": "managed", "type": "aws_security_group", "name": "web",
+      "instances": [{"attributes": {"name": "web"}}]
+    },
+    {
+      "mode": "data", "type": "aws_instance", "name": "other",
+      "instances": [{"attributes": {"public_ip": "10.0.0.3", "tags": {"Name": "other"}}}]
+    }
+  ]
+}`
+	err := ioutil.WriteFile("TestTerraform.tfstate", []byte(state), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	cfgStr := `
+[inventory "aws"]
+terraform = TestTerraform.tfstate
+type = aws_instance
+name = ${tags.Name}
+addr = ${public_ip}:22
+tag = ${tags.Role}
+var = address=${resource.address}
+var = note=${tags.Note}$${role}
+build = app
+
+[build "app"]
+cmd = echo ${address}
+cmd = echo ${note}
+
+[vars]
+var = role=web`
+	err = ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hf.Hosts) != 2 || len(hf.GetHosts("@web")) != 2 {
+		t.Error("Expected two web hosts, Got:", hf.Hosts)
+	}
+	p := hf.Host("web-02")
+	if p.Addr != "10.0.0.2:22" {
+		t.Error("Want:", "10.0.0.2:22", "Got:", p.Addr)
+	}
+	w := []string{"echo aws_instance.web[1]", "echo ${nope} ${env.HOME}web"}
+	if !reflect.DeepEqual(w, p.Cmds()) {
+		t.Error("Want:", w, "Got:", p.Cmds())
+	}
+
+	show := `{
+  "format_version": "1.0",
+  "values": {
+    "root_module": {
+      "resources": [],
+      "child_modules": [{
+        "resources": [{
+          "address": "module.app.aws_instance.app", "mode": "managed", "type": "aws_instance", "name": "app",
+          "values": {"public_ip": "10.0.0.4", "tags": {"Name": "app-01", "Role": "app", "Note": ""}}
+        }]
+      }]
+    }
+  }
+}`
+	err = ioutil.WriteFile("TestTerraform.tfstate", []byte(show), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	hf, err = NewHapfile("TestHapfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := hf.Host("app-01"); p == nil || p.Addr != "10.0.0.4:22" {
+		t.Error("Expected host app-01, Got:", hf.Hosts)
+	}
+
+	state = `{"version": 4, "resources": [{"mode": "managed", "type": "aws_instance", "name": "web",
+  "instances": [{"attributes": {"public_ip": null, "tags": {"Name": "web-01"}}}]}]}`
+	err = ioutil.WriteFile("TestTerraform.tfstate", []byte(state), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := NewHapfile("TestHapfile"); err == nil {
+		t.Error("Expected error for a missing attribute")
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	err = os.Remove("TestTerraform.tfstate")
+	if err != nil {
+		t.Error(err)
+	}
+}
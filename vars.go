@@ -0,0 +1,195 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Matches ${name} references, $${name} is left as ${name}
+var varPattern = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+// Vars holds the variables shared by all hosts
+type Vars struct {
+	Var []string
+}
+
+// ParseVars converts name=value entries into a map
+// Later entries override earlier entries with the same name.
+func ParseVars(entries []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, entry := range entries {
+		i := strings.Index(entry, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid var '%s', expects name=value", entry)
+		}
+		vars[strings.TrimSpace(entry[:i])] = strings.TrimSpace(entry[i+1:])
+	}
+	return vars, nil
+}
+
+// Interpolate replaces the ${name} references in s using lookup
+// Values returned by lookup are interpolated as well.
+func Interpolate(s string, lookup func(string) (string, bool)) (string, error) {
+	return interpolate(s, lookup, nil)
+}
+
+func interpolate(s string, lookup func(string) (string, bool), seen []string) (string, error) {
+	var err error
+	result := varPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		name := strings.TrimSpace(ref[2 : len(ref)-1])
+		for _, n := range seen {
+			if n == name {
+				err = fmt.Errorf("var cycle %s -> %s", strings.Join(seen, " -> "), name)
+				return ref
+			}
+		}
+		value, ok := lookup(name)
+		if !ok {
+			err = fmt.Errorf("undefined var '%s' in '%s'", name, s)
+			return ref
+		}
+		value, err = interpolate(value, lookup, append(seen, name))
+		return value
+	})
+	return result, err
+}
+
+// lookup finds the value of a var for the host
+// Names are host fields like host.name, local env like env.HOME,
+// or vars from the host, which has the default vars, and the vars
+// section in that order. Vars like !pass show token are kept as
+// secrets that are only run by ResolveSecrets.
+func (hf Hapfile) lookup(h *Host) (func(string) (string, bool), error) {
+	entries := append(append([]string{}, hf.Vars.Var...), h.Var...)
+	vars, err := ParseVars(entries)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range vars {
+		vars[name] = secret(value)
+	}
+	fields := map[string]string{
+		"host.name":     h.Name,
+		"host.addr":     h.Addr,
+		"host.dir":      h.GetDir(),
+		"host.username": h.Username,
+		"host.identity": h.Identity,
+	}
+	return func(name string) (string, bool) {
+		if value, ok := fields[name]; ok {
+			return value, true
+		}
+		if strings.HasPrefix(name, "env.") {
+			return os.LookupEnv(name[4:])
+		}
+		value, ok := vars[name]
+		return value, ok
+	}, nil
+}
+
+// interpolate replaces the var references in the host settings, files, and cmds
+func (hf Hapfile) interpolate(h *Host) error {
+	lookup, err := hf.lookup(h)
+	if err != nil {
+		return fmt.Errorf("[%s] %s", h.Name, err)
+	}
+	for _, field := range []*string{&h.Addr, &h.Dir, &h.Username, &h.Identity, &h.Password} {
+		if *field, err = Interpolate(*field, lookup); err != nil {
+			return fmt.Errorf("[%s] %s", h.Name, err)
+		}
+	}
+	h.Password = secret(h.Password)
+	if h.vars, err = interpolateVars(lookup, append(append([]string{}, hf.Vars.Var...), h.Var...)); err != nil {
+		return fmt.Errorf("[%s] %s", h.Name, err)
+	}
+	for i := range h.files {
+		for _, field := range []*string{&h.files[i].Src, &h.files[i].Dest} {
+			if *field, err = Interpolate(*field, lookup); err != nil {
+				return fmt.Errorf("[%s] file %s: %s", h.Name, h.files[i].name, err)
+			}
+		}
+	}
+	if h.Env, err = interpolateAll(h.Env, lookup); err != nil {
+		return fmt.Errorf("[%s] %s", h.Name, err)
+	}
+	return hf.interpolateCmds(h)
+}
+
+// interpolateCmds replaces the var references in the host cmds
+func (hf Hapfile) interpolateCmds(h *Host) error {
+	lookup, err := hf.lookup(h)
+	if err != nil {
+		return fmt.Errorf("[%s] %s", h.Name, err)
+	}
+	for _, cmds := range []*[]string{&h.cmds, &h.onFailure, &h.always} {
+		if *cmds, err = interpolateAll(*cmds, lookup); err != nil {
+			return fmt.Errorf("[%s] %s", h.Name, err)
+		}
+	}
+	return nil
+}
+
+// interpolateAll returns a new list with the references replaced
+func interpolateAll(values []string, lookup func(string) (string, bool)) ([]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+	results := make([]string, len(values))
+	for i, value := range values {
+		result, err := Interpolate(value, lookup)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// checkVars reports undefined vars for every host and deploy host
+func (hf Hapfile) checkVars() []error {
+	if _, err := ParseVars(hf.Vars.Var); err != nil {
+		return []error{err}
+	}
+	errs := []error{}
+	for _, name := range sortedNames(hf.Hosts) {
+		if err := hf.interpolate(hf.resolve(name, hf.Hosts[name])); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	deploys := []string{}
+	for d := range hf.deploys {
+		deploys = append(deploys, d)
+	}
+	sort.Strings(deploys)
+	for _, d := range deploys {
+		for _, name := range sortedNames(hf.deploys[d]) {
+			if err := hf.interpolate(hf.resolve(name, hf.deploys[d][name])); err != nil {
+				errs = append(errs, fmt.Errorf("[deploy %s] %s", d, err))
+			}
+		}
+	}
+	return errs
+}
+
+// sortedNames returns the sorted names of the hosts
+func sortedNames(hosts map[string]*Host) []string {
+	names := []string{}
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
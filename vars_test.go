@@ -0,0 +1,105 @@
+// Hap - the simple and effective provisioner
+// Copyright (c) 2019 GWoo (https://github.com/gwoo)
+// The BSD License http://opensource.org/licenses/bsd-license.php.
+
+package hap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{"app": "/srv/${name}", "name": "hap", "loop": "${loop}"}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"cd ${app}", "cd /srv/hap"},
+		{"${name}-${ name }", "hap-hap"},
+		{"echo $${HOME} $HOME", "echo ${HOME} $HOME"},
+		{"no vars", "no vars"},
+	}
+	for _, test := range tests {
+		got, err := Interpolate(test.value, lookup)
+		if err != nil {
+			t.Error(err)
+		}
+		if got != test.want {
+			t.Error("Want:", test.want, "Got:", got)
+		}
+	}
+	if _, err := Interpolate("${missing}", lookup); err == nil {
+		t.Error("Expected error for undefined var")
+	}
+	if _, err := Interpolate("${loop}", lookup); err == nil {
+		t.Error("Expected error for var cycle")
+	}
+}
+
+func TestNewHapfileWithVars(t *testing.T) {
+	cfgStr := `
+[vars]
+var = root=/srv
+var = app=${root}/app
+
+[default]
+dir = ${app}
+build = deploy
+
+[host "one"]
+addr = "10.0.0.1:22"
+var = root=/opt
+cmd = echo ${host.name} ${host.addr}
+
+[deploy "release"]
+host = one
+var = release=${env.TEST_HAP_RELEASE}
+cmd = echo ${release}
+
+[build "deploy"]
+cmd = cd ${app}`
+	err := ioutil.WriteFile("TestHapfile", []byte(cfgStr), 0666)
+	if err != nil {
+		t.Error(err)
+	}
+	os.Setenv("TEST_HAP_RELEASE", "v1")
+	hf, err := NewHapfile("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+	p := hf.Host("one")
+	ws := "/opt/app"
+	gs := p.Dir
+	if ws != gs {
+		t.Error("Want:", ws, "Got:", gs)
+	}
+	w := []string{"cd /opt/app", "echo one 10.0.0.1:22"}
+	if !reflect.DeepEqual(w, p.Cmds()) {
+		t.Error("Want:", w, "Got:", p.Cmds())
+	}
+	p = hf.DeployHost("release", "one")
+	w = []string{"cd /opt/app", "echo v1"}
+	if !reflect.DeepEqual(w, p.Cmds()) {
+		t.Error("Want:", w, "Got:", p.Cmds())
+	}
+	w = []string{"echo ${host.name} ${host.addr}"}
+	if !reflect.DeepEqual(w, hf.Hosts["one"].Cmd) {
+		t.Error("Want:", w, "Got:", hf.Hosts["one"].Cmd)
+	}
+
+	os.Unsetenv("TEST_HAP_RELEASE")
+	if _, err := NewHapfile("TestHapfile"); err == nil {
+		t.Error("Expected error for undefined env var")
+	}
+	err = os.Remove("TestHapfile")
+	if err != nil {
+		t.Error(err)
+	}
+}